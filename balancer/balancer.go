@@ -3,6 +3,7 @@ package balancer
 import (
 	"math/rand"
 	"sync"
+	"time"
 )
 
 type SelectMode int
@@ -11,13 +12,44 @@ const (
 	RandomSelect SelectMode = iota
 	RoundRobinSelect
 	ConsistentHashSelect
+	WeightedRoundRobinSelect
+	LeastOutstandingSelect
+	P2CSelect
+	BoundedConsistentHashSelect
+	RendezvousSelect
+	MaglevSelect
+	ZoneAwareSelect
 )
 
+// DefaultLoadEpsilon 有界负载一致性哈希默认允许超出平均负载的比例
+const DefaultLoadEpsilon = 0.25
+
+// Balancer 从addrs中为方法mth选出一台服务器，clientAddr为路由键：
+// 默认是客户端标识，调用方通过client.WithRoutingKey指定时为该键
 type Balancer interface {
 	Next(mth string, clientAddr string, addrs []string) string
 	refresh(mth string, addrs []string) error
 }
 
+// 服务器元数据中的常用键
+const (
+	MetaWeight  = "weight"
+	MetaZone    = "zone"
+	MetaRegion  = "region"
+	MetaVersion = "version"
+)
+
+// MetaAware 需要根据服务器元数据（权重、机房等）做选择的负载均衡器实现该接口
+type MetaAware interface {
+	UpdateMeta(addr string, meta map[string]string)
+}
+
+// MembershipAware 按服务器保存状态（负载、延迟等）的负载均衡器实现该接口，
+// 在服务发现返回方法的完整服务器列表时清理已下线服务器的状态
+type MembershipAware interface {
+	UpdateMembers(mth string, addrs []string)
+}
+
 type BalancerX struct {
 	mu        sync.Mutex
 	balancers []Balancer
@@ -31,6 +63,50 @@ func (bx *BalancerX) Next(strategy, method, clientAddr string, addrs []string) s
 	return bx.balancers[mode].Next(method, clientAddr, addrs)
 }
 
+// UpdateMeta 将服务器最新的元数据通知给所有MetaAware的负载均衡器
+func (bx *BalancerX) UpdateMeta(addr string, meta map[string]string) {
+	bx.mu.Lock()
+	defer bx.mu.Unlock()
+	for _, b := range bx.balancers {
+		if m, ok := b.(MetaAware); ok {
+			m.UpdateMeta(addr, meta)
+		}
+	}
+}
+
+// UpdateMembers 将方法method当前的完整服务器列表通知给所有MembershipAware的负载均衡器，
+// 应在路由规则与子集筛选之前调用
+func (bx *BalancerX) UpdateMembers(method string, addrs []string) {
+	bx.mu.Lock()
+	defer bx.mu.Unlock()
+	for _, b := range bx.balancers {
+		if m, ok := b.(MembershipAware); ok {
+			m.UpdateMembers(method, addrs)
+		}
+	}
+}
+
+// Start 在调用发起前通知该策略的负载均衡器，仅对实现了Feedback的负载均衡器生效
+func (bx *BalancerX) Start(strategy, method, addr string) {
+	if f, ok := bx.feedback(strategy); ok {
+		f.Start(method, addr)
+	}
+}
+
+// Done 在调用结束后将结果反馈给该策略的负载均衡器
+func (bx *BalancerX) Done(strategy, method, addr string, latency time.Duration, err error) {
+	if f, ok := bx.feedback(strategy); ok {
+		f.Done(method, addr, latency, err)
+	}
+}
+
+func (bx *BalancerX) feedback(strategy string) (Feedback, bool) {
+	bx.mu.Lock()
+	defer bx.mu.Unlock()
+	f, ok := bx.balancers[bx.Strategy[strategy]].(Feedback)
+	return f, ok
+}
+
 func (bx *BalancerX) Register(name string, balancer Balancer) SelectMode {
 	bx.mu.Lock()
 	defer bx.mu.Unlock()
@@ -39,6 +115,18 @@ func (bx *BalancerX) Register(name string, balancer Balancer) SelectMode {
 	bx.Strategy[name] = SelectMode(idx)
 	return SelectMode(idx)
 }
+
+// Balancer 返回以name注册的负载均衡器，不存在时返回nil
+func (bx *BalancerX) Balancer(name string) Balancer {
+	bx.mu.Lock()
+	defer bx.mu.Unlock()
+	mode, ok := bx.Strategy[name]
+	if !ok {
+		return nil
+	}
+	return bx.balancers[mode]
+}
+
 func (bx *BalancerX) GetALL() []string {
 	strategys := make([]string, 0)
 	for k, _ := range bx.Strategy {
@@ -55,23 +143,31 @@ func NewBalancerX() *BalancerX {
 		Strategy:  map[string]SelectMode{},
 	}
 	b.Register("RandomSelect", &RandomBalancer{
-		r: rand.Rand{},
-	})
-	b.Register("RoundRobin", &RoundRobinBalancer{
-		mu:   sync.Mutex{},
-		last: map[string]int{},
-	})
-	b.Register("ConsistentHash", &ConsistentHashBalancer{
-		ringMap: map[string]*ring{},
+		r: rand.New(rand.NewSource(time.Now().UnixNano())),
 	})
+	b.Register("RoundRobin", NewRoundRobinBalancer())
+	b.Register("ConsistentHash", NewConsistentHashBalancer())
+	b.Register("WeightedRoundRobin", NewWeightedRoundRobinBalancer())
+	b.Register("LeastOutstanding", NewLeastOutstandingBalancer())
+	b.Register("P2C", NewP2CBalancer())
+	b.Register("BoundedConsistentHash", NewBoundedConsistentHashBalancer(DefaultLoadEpsilon))
+	b.Register("Rendezvous", NewRendezvousBalancer())
+	b.Register("Maglev", NewMaglevBalancer(DefaultMaglevTableSize))
+	b.Register("ZoneAware", NewZoneAwareBalancer("", DefaultSpillThreshold, nil)) // 机房由XClient.SetZone指定
 	return b
 }
 
 type RandomBalancer struct {
-	r rand.Rand
+	mu sync.Mutex
+	r  *rand.Rand
 }
 
 func (b *RandomBalancer) Next(mth string, clientAddr string, addrs []string) string {
+	if len(addrs) == 0 {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	return addrs[b.r.Intn(len(addrs))]
 }
 
@@ -84,7 +180,14 @@ type RoundRobinBalancer struct {
 	last map[string]int
 }
 
+func NewRoundRobinBalancer() *RoundRobinBalancer {
+	return &RoundRobinBalancer{last: map[string]int{}}
+}
+
 func (b *RoundRobinBalancer) Next(mth string, clientAddr string, addrs []string) string {
+	if len(addrs) == 0 {
+		return ""
+	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	next := b.last[mth]
@@ -0,0 +1,275 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed:"+msg, v...))
+	}
+}
+
+func TestWeightedRoundRobinBalancer(t *testing.T) {
+	bx := NewBalancerX()
+	bx.UpdateMeta("a", map[string]string{MetaWeight: "5"})
+	bx.UpdateMeta("b", map[string]string{MetaWeight: "1"})
+	addrs := []string{"a", "b", "c"} // c未上报权重，按1处理
+
+	seq := ""
+	for i := 0; i < 7; i++ {
+		seq += bx.Next("WeightedRoundRobin", "Foo.Sum", "", addrs)
+	}
+	_assert(seq == "aabacaa", "expect smooth sequence aabacaa, got %s", seq)
+
+	bx.UpdateMeta("a", map[string]string{MetaWeight: "0"})
+	count := map[string]int{}
+	for i := 0; i < 10; i++ {
+		count[bx.Next("WeightedRoundRobin", "Foo.Sum", "", addrs)]++
+	}
+	_assert(count["a"] == 0 && count["b"] == 5 && count["c"] == 5, "expect weight update to take effect, got %v", count)
+	_assert(bx.Next("WeightedRoundRobin", "Foo.Sum", "", nil) == "", "expect empty result without servers")
+}
+
+func TestLeastOutstandingBalancer(t *testing.T) {
+	bx := NewBalancerX()
+	addrs := []string{"a", "b", "c"}
+	for i := 0; i < 3; i++ {
+		addr := bx.Next("LeastOutstanding", "Foo.Sum", "", addrs)
+		bx.Start("LeastOutstanding", "Foo.Sum", addr)
+	}
+	// 三台服务器各有一个未完成请求，b结束后应选择b
+	bx.Done("LeastOutstanding", "Foo.Sum", "b", time.Millisecond, nil)
+	_assert(bx.Next("LeastOutstanding", "Foo.Sum", "", addrs) == "b", "expect the server without outstanding requests")
+
+	// 未完成请求数相同时选择延迟更低的
+	bx.Done("LeastOutstanding", "Foo.Sum", "a", 50*time.Millisecond, nil)
+	bx.Done("LeastOutstanding", "Foo.Sum", "c", 10*time.Millisecond, nil)
+	for i := 0; i < 3; i++ {
+		_assert(bx.Next("LeastOutstanding", "Foo.Sum", "", addrs) == "b", "expect the fastest idle server")
+	}
+}
+
+func TestP2CBalancer(t *testing.T) {
+	bx := NewBalancerX()
+	addrs := []string{"slow", "fast"}
+	bx.Done("P2C", "Foo.Sum", "slow", 100*time.Millisecond, nil)
+	bx.Done("P2C", "Foo.Sum", "fast", time.Millisecond, nil)
+	for i := 0; i < 10; i++ {
+		_assert(bx.Next("P2C", "Foo.Sum", "", addrs) == "fast", "expect the cheaper of two choices")
+	}
+	// 失败会被计入延迟惩罚
+	for i := 0; i < 5; i++ {
+		bx.Done("P2C", "Foo.Sum", "fast", time.Millisecond, errors.New("boom"))
+	}
+	_assert(bx.Next("P2C", "Foo.Sum", "", addrs) == "slow", "expect failing server to be penalized")
+	_assert(bx.Next("P2C", "Foo.Sum", "", []string{"only"}) == "only", "expect the single server")
+}
+
+func TestLoadTracker_UpdateMembers(t *testing.T) {
+	bx := NewBalancerX()
+	bx.Done("P2C", "Foo.Sum", "slow", 100*time.Millisecond, nil)
+	bx.Done("P2C", "Foo.Sum", "fast", time.Millisecond, nil)
+	// 其他方法或路由规则使用不同的候选列表，不影响已有的延迟统计
+	_ = bx.Next("P2C", "Bar.Sum", "", []string{"other", "another"})
+	_ = bx.Next("P2C", "Foo.Sum", "", []string{"canary", "fast"})
+	bx.UpdateMembers("Bar.Sum", []string{"other"})
+	for i := 0; i < 10; i++ {
+		_assert(bx.Next("P2C", "Foo.Sum", "", []string{"slow", "fast"}) == "fast", "expect latency history to survive other candidate lists")
+	}
+	// 服务器下线后清理其统计，重新上线时从零开始
+	bx.UpdateMembers("Foo.Sum", []string{"fast"})
+	bx.UpdateMembers("Foo.Sum", []string{"slow", "fast"})
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		seen[bx.Next("P2C", "Foo.Sum", "", []string{"slow", "fast"})] = true
+	}
+	_assert(seen["slow"], "expect the removed server to start cold, got %v", seen)
+}
+
+func TestBoundedConsistentHashBalancer(t *testing.T) {
+	bx := NewBalancerX()
+	addrs := []string{"a", "b", "c", "d"}
+	hot := bx.Next("BoundedConsistentHash", "Foo.Sum", "hot-key", addrs)
+	_assert(hot == bx.Next("ConsistentHash", "Foo.Sum", "hot-key", addrs), "expect same node as plain consistent hash when idle")
+
+	// 同一个热点键的请求不会全部落在同一节点上
+	count := map[string]int{}
+	for i := 0; i < 40; i++ {
+		addr := bx.Next("BoundedConsistentHash", "Foo.Sum", "hot-key", addrs)
+		bx.Start("BoundedConsistentHash", "Foo.Sum", addr)
+		count[addr]++
+	}
+	for addr, n := range count {
+		_assert(n <= 13, "expect load of %s bounded by (1+ε)×average, got %v", addr, count)
+	}
+	_assert(count[hot] > 0, "expect the hashed node to take its share, got %v", count)
+
+	// 负载回落后重新回到原节点
+	for addr, n := range count {
+		for i := 0; i < n; i++ {
+			bx.Done("BoundedConsistentHash", "Foo.Sum", addr, time.Millisecond, nil)
+		}
+	}
+	_assert(bx.Next("BoundedConsistentHash", "Foo.Sum", "hot-key", addrs) == hot, "expect key to return to its node")
+}
+
+func TestHashingBalancers(t *testing.T) {
+	for _, strategy := range []string{"Rendezvous", "Maglev"} {
+		t.Run(strategy, func(t *testing.T) {
+			bx := NewBalancerX()
+			addrs := []string{"a", "b", "c", "d"}
+			bx.UpdateMeta("a", map[string]string{MetaWeight: "3"})
+			before := map[string]string{}
+			count := map[string]int{}
+			for i := 0; i < 6000; i++ {
+				key := strconv.Itoa(i)
+				before[key] = bx.Next(strategy, "Foo.Sum", key, addrs)
+				_assert(before[key] == bx.Next(strategy, "Foo.Sum", key, addrs), "expect the same server for the same key")
+				count[before[key]]++
+			}
+			// a的权重是其他服务器的3倍，应承担约一半的键
+			_assert(count["a"] > 2600 && count["a"] < 3400, "expect weighted share, got %v", count)
+
+			// 移除d后，原本不在d上的键几乎都不迁移
+			moved := 0
+			for key, addr := range before {
+				if addr != "d" && bx.Next(strategy, "Foo.Sum", key, addrs[:3]) != addr {
+					moved++
+				}
+			}
+			_assert(moved < 300, "expect minimal disruption, %d keys moved", moved)
+			_assert(bx.Next(strategy, "Foo.Sum", "k", nil) == "", "expect empty result without servers")
+		})
+	}
+}
+
+func TestMaglevTableSize(t *testing.T) {
+	for _, size := range []int{1, 2, 10, 12, 100} {
+		b := NewMaglevBalancer(size)
+		_assert(b.size >= uint32(size) && nextPrime(b.size) == b.size, "expect a prime size for %d, got %d", size, b.size)
+		done := make(chan string)
+		go func() { done <- b.Next("Foo.Sum", "k", []string{"a", "b", "c"}) }()
+		select {
+		case addr := <-done:
+			_assert(addr != "", "expect a server for size %d", size)
+		case <-time.After(time.Second):
+			_assert(false, "populate doesn't terminate for size %d", size)
+		}
+	}
+}
+
+func TestZoneAwareBalancer(t *testing.T) {
+	b := NewZoneAwareBalancer("a", DefaultSpillThreshold, nil)
+	addrs := []string{"a1", "a2", "b1", "b2"}
+	for _, addr := range addrs {
+		b.UpdateMeta(addr, map[string]string{MetaZone: addr[:1]})
+	}
+	pick := func() map[string]int {
+		count := map[string]int{}
+		for i := 0; i < 8; i++ {
+			count[b.Next("Foo.Sum", "", addrs)]++
+		}
+		return count
+	}
+	count := pick()
+	_assert(count["a1"] == 4 && count["a2"] == 4, "expect only local servers, got %v", count)
+
+	fail := func(addr string) {
+		for i := 0; i < maxFailures; i++ {
+			b.Done("Foo.Sum", addr, time.Millisecond, errors.New("boom"))
+		}
+	}
+	// 本机房仍有一半健康容量，不溢出
+	fail("a1")
+	count = pick()
+	_assert(count["a2"] == 8, "expect the healthy local server, got %v", count)
+
+	// 本机房容量不足，溢出到其他机房
+	fail("a2")
+	count = pick()
+	_assert(count["b1"] == 4 && count["b2"] == 4, "expect spillover to remote zone, got %v", count)
+
+	b.Done("Foo.Sum", "a2", time.Millisecond, nil)
+	count = pick()
+	_assert(count["a2"] == 8, "expect traffic back to the recovered local server, got %v", count)
+
+	// 修改机房不会丢失已记录的健康状态
+	fail("b1")
+	b.SetZone("b", DefaultSpillThreshold)
+	count = pick()
+	_assert(count["b2"] == 8, "expect the healthy server in the new zone, got %v", count)
+
+	// 本机房服务器权重全部为0时溢出
+	for _, addr := range []string{"b1", "b2"} {
+		b.UpdateMeta(addr, map[string]string{MetaZone: "b", MetaWeight: "0"})
+	}
+	count = pick()
+	_assert(count["a2"] == 4 && count["b2"] == 4, "expect spillover from zero-weight local servers, got %v", count)
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter()
+	addrs := []string{"stable1", "stable2", "canary"}
+	r.UpdateMeta("canary", map[string]string{"canary": "true"})
+	_assert(len(r.Route("Foo.Sum", nil, addrs)) == 3, "expect all servers without rules")
+
+	r.SetRules("Foo.Sum", []Rule{
+		{Method: "Foo", Match: map[string]string{"user-group": "beta"}, Subset: map[string]string{"canary": "true"}, Percent: 100},
+		{Method: "Foo.Sum", Subset: map[string]string{"canary": "true"}, Percent: 20},
+	})
+	beta := r.Route("Foo.Sum", map[string]string{"user-group": "beta"}, addrs)
+	_assert(len(beta) == 1 && beta[0] == "canary", "expect beta users on canary, got %v", beta)
+	canary := 0
+	for i := 0; i < 1000; i++ {
+		if got := r.Route("Foo.Sum", nil, addrs); len(got) == 1 && got[0] == "canary" {
+			canary++
+		} else {
+			_assert(len(got) == 2, "expect stable servers, got %v", got)
+		}
+	}
+	_assert(canary > 130 && canary < 270, "expect about 20%% canary traffic, got %d", canary)
+	_assert(len(r.Route("Foo.Sum", nil, addrs[:2])) == 2, "expect fallback when subset is empty")
+
+	rule := Rule{Method: "billing.v2.Invoice", Subset: map[string]string{"canary": "true"}, Percent: 101}
+	_assert(rule.AppliesTo("billing.v2.Invoice.Create") && !rule.AppliesTo("billing.v2.Create"), "unexpected method match")
+	_assert(rule.Validate() != nil, "expect percent out of range")
+}
+
+func TestSubset(t *testing.T) {
+	addrs := make([]string, 20)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("tcp@10.0.0.%d:8080", i)
+	}
+	_assert(len(Subset("client", addrs[:3], 5)) == 3, "expect all servers when fewer than subset size")
+
+	connections := map[string]int{}
+	for c := 0; c < 1000; c++ {
+		id := "client-" + strconv.Itoa(c)
+		subset := Subset(id, addrs, 5)
+		_assert(len(subset) == 5, "expect subset size 5, got %v", subset)
+		_assert(fmt.Sprint(subset) == fmt.Sprint(Subset(id, addrs, 5)), "expect deterministic subset")
+		for _, addr := range subset {
+			connections[addr]++
+		}
+		// 新增一台服务器时子集平滑变化
+		grown := Subset(id, append(addrs, "tcp@10.0.0.99:8080"), 5)
+		kept := 0
+		for _, a := range grown {
+			for _, b := range subset {
+				if a == b {
+					kept++
+				}
+			}
+		}
+		_assert(kept >= 3, "expect at most two servers to change, got %v -> %v", subset, grown)
+	}
+	// 每台服务器期望250个连接
+	for addr, n := range connections {
+		_assert(n > 150 && n < 350, "expect balanced connections, %s got %d", addr, n)
+	}
+}
@@ -1,21 +1,72 @@
 package balancer
 
 import (
-	"crypto"
 	"errors"
-	"hash"
+	"math"
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 )
 
+// DefaultVirtualNodes 每个真实节点默认对应的虚拟节点数
+const DefaultVirtualNodes = 32
+
+// RingOption 哈希环参数
+type RingOption struct {
+	Hash         HashFunc // 为空时使用MD5Hash
+	VirtualNodes int      // 不大于0时使用DefaultVirtualNodes
+}
+
+var DefaultRingOption = &RingOption{
+	Hash:         MD5Hash,
+	VirtualNodes: DefaultVirtualNodes,
+}
+
+func parseRingOptions(opts ...*RingOption) *RingOption {
+	if len(opts) == 0 || opts[0] == nil {
+		return DefaultRingOption
+	}
+	opt := *opts[0]
+	if opt.Hash == nil {
+		opt.Hash = DefaultRingOption.Hash
+	}
+	if opt.VirtualNodes <= 0 {
+		opt.VirtualNodes = DefaultRingOption.VirtualNodes
+	}
+	return &opt
+}
+
 type ConsistentHashBalancer struct {
 	mu      sync.Mutex
+	opt     *RingOption
 	ringMap map[string]*ring
+	epsilon float64     // 大于0时启用有界负载：每个节点的负载不超过平均值的(1+epsilon)倍
+	loads   loadTracker // 各节点未完成的请求数
+}
+
+var (
+	_ Feedback        = (*ConsistentHashBalancer)(nil)
+	_ MembershipAware = (*ConsistentHashBalancer)(nil)
+)
+
+func NewConsistentHashBalancer(opts ...*RingOption) *ConsistentHashBalancer {
+	return &ConsistentHashBalancer{
+		opt:     parseRingOptions(opts...),
+		ringMap: map[string]*ring{},
+		loads:   newLoadTracker(),
+	}
+}
+
+// NewBoundedConsistentHashBalancer 带有界负载的一致性哈希：目标节点已满时沿环顺时针寻找下一个未满的节点，
+// 热点键不会压垮单台服务器
+func NewBoundedConsistentHashBalancer(epsilon float64, opts ...*RingOption) *ConsistentHashBalancer {
+	c := NewConsistentHashBalancer(opts...)
+	c.epsilon = epsilon
+	return c
 }
 
 func (c *ConsistentHashBalancer) Next(mth, clientAddr string, addrs []string) string {
-	//TODO implement me
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	err := c.refresh(mth, addrs)
@@ -23,193 +74,157 @@ func (c *ConsistentHashBalancer) Next(mth, clientAddr string, addrs []string) st
 		return ""
 	}
 	ring := c.ringMap[mth]
-	target, err := ring.Find(clientAddr)
+	accept := func(string) bool { return true }
+	if c.epsilon > 0 {
+		accept = c.underCapacity(mth, addrs)
+	}
+	target, err := ring.FindFunc(clientAddr, accept)
 	if err != nil {
 		return ""
 	}
 	return target
 }
 
+// 容量为 ceil((1+epsilon) * (当前总负载+1) / 节点数)，负载低于容量的节点可以接收新请求
+func (c *ConsistentHashBalancer) underCapacity(mth string, addrs []string) func(string) bool {
+	c.loads.mu.Lock()
+	defer c.loads.mu.Unlock()
+	var total int64
+	loads := make(map[string]int64, len(addrs))
+	for _, addr := range addrs {
+		loads[addr] = c.loads.load(mth, addr).inflight
+		total += loads[addr]
+	}
+	capacity := int64(math.Ceil((1 + c.epsilon) * float64(total+1) / float64(len(addrs))))
+	return func(addr string) bool {
+		return loads[addr] < capacity
+	}
+}
+
+func (c *ConsistentHashBalancer) Start(mth, addr string) {
+	c.loads.Start(mth, addr)
+}
+
+func (c *ConsistentHashBalancer) Done(mth, addr string, latency time.Duration, err error) {
+	c.loads.Done(mth, addr, latency, err)
+}
+
+func (c *ConsistentHashBalancer) UpdateMembers(mth string, addrs []string) {
+	c.loads.UpdateMembers(mth, addrs)
+}
+
 func (c *ConsistentHashBalancer) refresh(mth string, addrs []string) error {
 	ring, ok := c.ringMap[mth]
 	if !ok {
-		c.ringMap[mth] = newRing(crypto.MD5.New(), 32)
-		ring = c.ringMap[mth]
-	}
-	err := ring.Update(addrs)
-	if err != nil {
-		return err
+		ring = newRing(c.opt)
+		c.ringMap[mth] = ring
 	}
+	ring.Update(addrs)
 	return nil
 }
 
+// Distribution 返回某个方法当前哈希环的分布情况
+func (c *ConsistentHashBalancer) Distribution(mth string) DistributionReport {
+	c.mu.Lock()
+	ring, ok := c.ringMap[mth]
+	c.mu.Unlock()
+	if !ok {
+		return DistributionReport{Share: map[string]float64{}}
+	}
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	return ring.distribution()
+}
+
 var _ Balancer = (*ConsistentHashBalancer)(nil)
 
 type ring struct {
-	mu      sync.Mutex
-	hash    hash.Hash
-	virtual []string
-	ori     map[string]bool
-	numO2V  int                 // 每个真实节点对应多少个虚拟节点
-	v2o     map[string]string   // 虚拟节点映射到真实节点
-	o2v     map[string][]string // 真实节点映射到虚拟节点
+	mu       sync.Mutex
+	hash     HashFunc
+	replicas int               // 每个真实节点对应多少个虚拟节点
+	points   []uint32          // 有序的虚拟节点哈希值
+	owner    map[uint32]string // 虚拟节点映射到真实节点
+	nodes    map[string]bool
 }
 
-func newRing(hash hash.Hash, num int) *ring {
+func newRing(opt *RingOption) *ring {
 	return &ring{
-		hash:    hash,
-		virtual: make([]string, 0),
-		numO2V:  num,
-		v2o:     map[string]string{},
-		o2v:     map[string][]string{},
-		ori:     map[string]bool{},
+		hash:     opt.Hash,
+		replicas: opt.VirtualNodes,
+		owner:    map[uint32]string{},
+		nodes:    map[string]bool{},
 	}
 }
 
-func (r *ring) reset() {
-	r.o2v = map[string][]string{}
-	r.v2o = map[string]string{}
-	r.ori = map[string]bool{}
-	r.virtual = make([]string, 0)
+func (r *ring) Find(key string) (string, error) {
+	return r.FindFunc(key, func(string) bool { return true })
 }
 
-func (r *ring) Find(clientAddr string) (string, error) {
+// FindFunc 从键在环上的位置顺时针查找第一个被accept接受的真实节点，都不接受时返回键对应的节点
+func (r *ring) FindFunc(key string, accept func(addr string) bool) (string, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if len(r.virtual) == 0 {
+	if len(r.points) == 0 {
 		return "", errors.New("no node in ring")
 	}
-	_, err := r.hash.Write([]byte(clientAddr))
-	if err != nil {
-		return "", err
-	}
-	clientHash := string(r.hash.Sum(nil))
-	r.hash.Reset()
-	idx := findUpper(r.virtual, clientHash)
-	if idx == -1 {
-		return r.v2o[r.virtual[0]], nil
-	} else {
-		return r.v2o[r.virtual[idx]], nil
+	h := r.hash([]byte(key))
+	idx := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if idx == len(r.points) {
+		idx = 0
+	}
+	first := r.owner[r.points[idx]]
+	tried := map[string]bool{}
+	for i := 0; i < len(r.points) && len(tried) < len(r.nodes); i++ {
+		addr := r.owner[r.points[(idx+i)%len(r.points)]]
+		if tried[addr] {
+			continue
+		}
+		if accept(addr) {
+			return addr, nil
+		}
+		tried[addr] = true
 	}
+	return first, nil
 }
 
-func (r *ring) Update(addrs []string) error {
+// Update 节点集合变化时重建哈希环，未变化的节点虚拟节点位置不变，重复的地址只计一次
+func (r *ring) Update(addrs []string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	sort.Strings(addrs)
-	for k, _ := range r.ori {
-		idx := find(addrs, k)
-		if idx == -1 {
-			if err := r.DeleteNode(k); err != nil {
-				return err
-			}
+	nodes := make(map[string]bool, len(addrs))
+	sorted := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if !nodes[addr] {
+			nodes[addr] = true
+			sorted = append(sorted, addr)
 		}
 	}
-	for _, item := range addrs {
-		if !r.ori[item] {
-			err := r.AddNode(item)
-			if err != nil {
-				return err
+	if len(nodes) == len(r.nodes) {
+		same := true
+		for addr := range nodes {
+			if !r.nodes[addr] {
+				same = false
+				break
 			}
 		}
-	}
-	return nil
-}
-
-func (r *ring) AddNode(addr string) error {
-	r.ori[addr] = true
-	for i := 0; i < r.numO2V; i++ {
-		cur := addr + "#" + strconv.Itoa(i)
-		_, err := r.hash.Write([]byte(cur))
-		if err != nil {
-			return err
-		}
-		a := r.hash.Sum(nil)
-		curHash := string(a)
-		r.hash.Reset()
-		r.virtual = addHash(r.virtual, curHash)
-		r.v2o[curHash] = addr
-		if _, ok := r.o2v[addr]; ok {
-			r.o2v[addr] = append(r.o2v[addr], curHash)
-		} else {
-			r.o2v[addr] = append([]string{}, curHash)
-		}
-	}
-	return nil
-}
-
-func (r *ring) DeleteNode(addr string) error {
-	if !r.ori[addr] {
-		return errors.New("addr dose not exist")
-	}
-	delete(r.ori, addr)
-	for _, item := range r.o2v[addr] {
-		delete(r.v2o, item)
-		r.virtual = deleteSlice(r.virtual, item)
-	}
-	delete(r.o2v, addr)
-	return nil
-}
-
-func deleteSlice(nums []string, cur string) []string {
-	idx := find(nums, cur)
-	if idx == -1 {
-		return nums
-	}
-	if idx == len(nums)-1 {
-		return nums[:idx]
-	}
-	res := append([]string{}, nums[idx+1:]...)
-	ans := append(nums[:idx], res...)
-	return ans
-}
-
-func find(nums []string, cur string) int {
-	if len(nums) == -1 {
-		return -1
-	}
-	left, right := 0, len(nums)-1
-	for left < right {
-		mid := left + (right-left)/2
-		if nums[mid] == cur {
-			return mid
-		} else if nums[mid] < cur {
-			left = mid + 1
-		} else {
-			right = mid - 1
+		if same {
+			return
 		}
 	}
-	if nums[left] != cur {
-		return -1
-	}
-	return right
-}
-func addHash(nums []string, cur string) []string {
-	idx := findUpper(nums, cur)
-	if idx == -1 {
-		nums = append(nums, cur)
-		return nums
-	}
-	res := append([]string{}, nums[idx:]...)
-	nums = append(append(nums[:idx], cur), res...)
-	return nums
-}
-
-func findUpper(nums []string, cur string) int {
-	if len(nums) == 0 {
-		return -1
-	}
-	left, right := 0, len(nums)-1
-	for left < right {
-		mid := left + (right-left)/2
-		if nums[mid] <= cur {
-			left = mid + 1
-		} else {
-			right = mid
+	sort.Strings(sorted)
+	r.nodes = make(map[string]bool, len(sorted))
+	r.owner = make(map[uint32]string, len(sorted)*r.replicas)
+	r.points = r.points[:0]
+	for _, addr := range sorted {
+		r.nodes[addr] = true
+		for i := 0; i < r.replicas; i++ {
+			h := r.hash([]byte(addr + "#" + strconv.Itoa(i)))
+			if _, ok := r.owner[h]; ok { // 哈希冲突时保留先加入的节点
+				continue
+			}
+			r.owner[h] = addr
+			r.points = append(r.points, h)
 		}
 	}
-	if right == len(nums)-1 && nums[right] <= cur {
-		return -1
-	}
-	return right
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
 }
@@ -0,0 +1,145 @@
+package balancer
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// HashFunc 将键映射到32位哈希环上
+type HashFunc func(data []byte) uint32
+
+// MD5Hash 取MD5摘要的前4字节，与早期版本的环保持相同的分布特性
+func MD5Hash(data []byte) uint32 {
+	sum := md5.Sum(data)
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func FNVHash(data []byte) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write(data)
+	return h.Sum32()
+}
+
+func CRC32Hash(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data)
+}
+
+const (
+	xxPrime1 uint32 = 2654435761
+	xxPrime2 uint32 = 2246822519
+	xxPrime3 uint32 = 3266489917
+	xxPrime4 uint32 = 668265263
+	xxPrime5 uint32 = 374761393
+)
+
+// XXHash 种子为0的xxHash32，速度快且雪崩效应好
+func XXHash(data []byte) uint32 {
+	return xxHash32(data, 0)
+}
+
+func xxHash32(data []byte, seed uint32) uint32 {
+	n := len(data)
+	var h uint32
+	if n >= 16 {
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for len(data) >= 16 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint32(data[0:]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint32(data[4:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint32(data[8:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint32(data[12:]))
+			data = data[16:]
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = seed + xxPrime5
+	}
+	h += uint32(n)
+	for ; len(data) >= 4; data = data[4:] {
+		h += binary.LittleEndian.Uint32(data) * xxPrime3
+		h = bits.RotateLeft32(h, 17) * xxPrime4
+	}
+	for _, b := range data {
+		h += uint32(b) * xxPrime5
+		h = bits.RotateLeft32(h, 11) * xxPrime1
+	}
+	h ^= h >> 15
+	h *= xxPrime2
+	h ^= h >> 13
+	h *= xxPrime3
+	h ^= h >> 16
+	return h
+}
+
+func xxRound(acc, input uint32) uint32 {
+	return bits.RotateLeft32(acc+input*xxPrime2, 13) * xxPrime1
+}
+
+// DistributionReport 各节点在哈希环上所占的比例，用于比较不同哈希函数与虚拟节点数的均衡程度
+type DistributionReport struct {
+	Share map[string]float64 // 节点负责的哈希区间占整个环的比例
+	Mean  float64
+	CV    float64 // 变异系数，即标准差与均值之比
+	Min   float64
+	Max   float64
+}
+
+func (r DistributionReport) String() string {
+	nodes := make([]string, 0, len(r.Share))
+	for node := range r.Share {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	var b strings.Builder
+	for _, node := range nodes {
+		fmt.Fprintf(&b, "%s\t%.4f\n", node, r.Share[node])
+	}
+	fmt.Fprintf(&b, "mean=%.4f cv=%.2f%% min=%.4f max=%.4f", r.Mean, r.CV*100, r.Min, r.Max)
+	return b.String()
+}
+
+// Distribution 按给定参数构建哈希环，并统计各节点负责的区间比例
+func Distribution(addrs []string, opts ...*RingOption) DistributionReport {
+	r := newRing(parseRingOptions(opts...))
+	r.Update(addrs)
+	return r.distribution()
+}
+
+func (r *ring) distribution() DistributionReport {
+	report := DistributionReport{Share: make(map[string]float64, len(r.nodes))}
+	if len(r.points) == 0 {
+		return report
+	}
+	for node := range r.nodes {
+		report.Share[node] = 0
+	}
+	// 每个虚拟节点负责 (前一个点, 自身] 的区间，第一个点负责跨越0的区间
+	const space = float64(math.MaxUint32) + 1
+	prev := r.points[len(r.points)-1]
+	for _, p := range r.points {
+		report.Share[r.owner[p]] += float64(p-prev) / space
+		prev = p
+	}
+	if len(r.points) == 1 {
+		report.Share[r.owner[prev]] = 1
+	}
+	report.Mean = 1 / float64(len(report.Share))
+	report.Min = 1
+	var variance float64
+	for _, share := range report.Share {
+		variance += (share - report.Mean) * (share - report.Mean)
+		report.Min = math.Min(report.Min, share)
+		report.Max = math.Max(report.Max, share)
+	}
+	report.CV = math.Sqrt(variance/float64(len(report.Share))) / report.Mean
+	return report
+}
@@ -1 +1,130 @@
 package balancer
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+var hashFuncs = map[string]HashFunc{
+	"MD5":   MD5Hash,
+	"FNV":   FNVHash,
+	"CRC32": CRC32Hash,
+	"XX":    XXHash,
+}
+
+func nodes(n int) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("tcp@10.0.0.%d:8080", i)
+	}
+	return addrs
+}
+
+func TestXXHash(t *testing.T) {
+	for input, want := range map[string]uint32{
+		"":    0x02cc5d05,
+		"abc": 0x32d153ff,
+		"Nobody inspects the spammish repetition": 0xe2293b2f,
+	} {
+		_assert(XXHash([]byte(input)) == want, "xxhash(%q) = %#x, want %#x", input, XXHash([]byte(input)), want)
+	}
+}
+
+func TestRingDistribution(t *testing.T) {
+	addrs := nodes(10)
+	for name, fn := range hashFuncs {
+		t.Run(name, func(t *testing.T) {
+			few := Distribution(addrs, &RingOption{Hash: fn, VirtualNodes: 4})
+			many := Distribution(addrs, &RingOption{Hash: fn, VirtualNodes: 500})
+			_assert(len(many.Share) == len(addrs), "expect every node in report:\n%s", many)
+			var sum float64
+			for _, share := range many.Share {
+				sum += share
+			}
+			_assert(sum > 0.999 && sum < 1.001, "expect shares to cover the ring, got %f", sum)
+			_assert(many.CV < few.CV, "expect more virtual nodes to balance better: %f vs %f", many.CV, few.CV)
+			// FNV与CRC32对相似的地址雪崩效应差，只比较相对效果
+			if name == "MD5" || name == "XX" {
+				_assert(many.CV < 0.1, "expect cv under 10%%:\n%s", many)
+			}
+		})
+	}
+}
+
+func TestRingStability(t *testing.T) {
+	before := newRing(parseRingOptions())
+	before.Update(nodes(10))
+	after := newRing(parseRingOptions())
+	after.Update(nodes(11))
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		a, _ := before.Find(key)
+		b, _ := after.Find(key)
+		if a != b {
+			_assert(b == nodes(11)[10], "expect keys to move only to the new node, %s -> %s", a, b)
+			moved++
+		}
+	}
+	_assert(moved > 0 && moved < 250, "expect about 1/11 of keys to move, got %d", moved)
+}
+
+func TestRingUpdateDuplicates(t *testing.T) {
+	r := newRing(parseRingOptions())
+	r.Update([]string{"a", "b", "c"})
+	// 去重后节点数与之前相同，但c已下线，需要重建
+	r.Update([]string{"a", "a", "b"})
+	for i := 0; i < 100; i++ {
+		addr, _ := r.Find(strconv.Itoa(i))
+		_assert(addr == "a" || addr == "b", "expect removed node to be gone, got %s", addr)
+	}
+}
+
+func BenchmarkRingFind(b *testing.B) {
+	for name, fn := range hashFuncs {
+		b.Run(name, func(b *testing.B) {
+			r := newRing(&RingOption{Hash: fn, VirtualNodes: 160})
+			r.Update(nodes(100))
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = "user-" + strconv.Itoa(i)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = r.Find(keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+// 以自定义指标报告分布的变异系数，便于选择哈希函数与虚拟节点数：go test -bench Distribution ./balancer
+func BenchmarkRingDistribution(b *testing.B) {
+	for name, fn := range hashFuncs {
+		for _, vnodes := range []int{32, 160, 1000} {
+			b.Run(fmt.Sprintf("%s/%d", name, vnodes), func(b *testing.B) {
+				var report DistributionReport
+				for i := 0; i < b.N; i++ {
+					report = Distribution(nodes(50), &RingOption{Hash: fn, VirtualNodes: vnodes})
+				}
+				b.ReportMetric(report.CV*100, "cv%")
+				b.ReportMetric(report.Max/report.Mean, "max/mean")
+			})
+		}
+	}
+}
+
+// 比较不同哈希方案在大集群下的查找开销
+func BenchmarkHashingNext(b *testing.B) {
+	addrs := nodes(200)
+	for _, strategy := range []string{"ConsistentHash", "Rendezvous", "Maglev"} {
+		b.Run(strategy, func(b *testing.B) {
+			bx := NewBalancerX()
+			bx.Next(strategy, "Foo.Sum", "warmup", addrs)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bx.Next(strategy, "Foo.Sum", strconv.Itoa(i&1023), addrs)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package balancer
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Feedback 需要感知调用过程的负载均衡器实现该接口，
+// XClient在发起调用前回调Start，调用结束（包括失败）后回调Done
+type Feedback interface {
+	Start(mth, addr string)
+	Done(mth, addr string, latency time.Duration, err error)
+}
+
+const (
+	ewmaAlpha    = 0.3         // 新样本在EWMA中的权重
+	errorPenalty = time.Second // 失败的调用按额外的延迟计入，使出错的服务器被降权
+)
+
+type addrLoad struct {
+	inflight int64
+	ewma     float64 // 延迟的指数加权平均，单位纳秒
+}
+
+// 负载按方法与服务器分别统计，不同方法的候选列表互不影响
+type loadKey struct {
+	mth, addr string
+}
+
+// loadTracker 按方法与服务器统计未完成的请求数与延迟
+type loadTracker struct {
+	mu    sync.Mutex
+	loads map[loadKey]*addrLoad
+}
+
+func newLoadTracker() loadTracker {
+	return loadTracker{loads: map[loadKey]*addrLoad{}}
+}
+
+// 调用方需持有t.mu
+func (t *loadTracker) load(mth, addr string) *addrLoad {
+	k := loadKey{mth, addr}
+	l, ok := t.loads[k]
+	if !ok {
+		l = &addrLoad{}
+		t.loads[k] = l
+	}
+	return l
+}
+
+func (t *loadTracker) Start(mth, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.load(mth, addr).inflight++
+}
+
+func (t *loadTracker) Done(mth, addr string, latency time.Duration, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := t.load(mth, addr)
+	if l.inflight > 0 {
+		l.inflight--
+	}
+	if err != nil {
+		latency += errorPenalty
+	}
+	if l.ewma == 0 {
+		l.ewma = float64(latency)
+	} else {
+		l.ewma = ewmaAlpha*float64(latency) + (1-ewmaAlpha)*l.ewma
+	}
+}
+
+// UpdateMembers 移除方法mth已下线的服务器的统计。addrs必须是服务发现返回的完整列表，
+// 而不是经过路由或子集筛选后的候选列表，否则暂时未被选中的服务器会丢失延迟历史
+func (t *loadTracker) UpdateMembers(mth string, addrs []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	present := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		present[addr] = true
+	}
+	for k, l := range t.loads {
+		if k.mth == mth && !present[k.addr] && l.inflight == 0 {
+			delete(t.loads, k)
+		}
+	}
+}
+
+// LeastOutstandingBalancer 选择未完成请求最少的服务器，相同时选择延迟更低的，再相同时轮询
+type LeastOutstandingBalancer struct {
+	loadTracker
+	next int
+}
+
+var (
+	_ Balancer        = (*LeastOutstandingBalancer)(nil)
+	_ Feedback        = (*LeastOutstandingBalancer)(nil)
+	_ MembershipAware = (*LeastOutstandingBalancer)(nil)
+)
+
+func NewLeastOutstandingBalancer() *LeastOutstandingBalancer {
+	return &LeastOutstandingBalancer{loadTracker: newLoadTracker()}
+}
+
+func (b *LeastOutstandingBalancer) Next(mth string, clientAddr string, addrs []string) string {
+	if len(addrs) == 0 {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.next++
+	var best *addrLoad
+	target := ""
+	for i := range addrs {
+		addr := addrs[(b.next+i)%len(addrs)]
+		l := b.load(mth, addr)
+		if best == nil || l.inflight < best.inflight || (l.inflight == best.inflight && l.ewma < best.ewma) {
+			best, target = l, addr
+		}
+	}
+	return target
+}
+
+func (b *LeastOutstandingBalancer) refresh(mth string, addrs []string) error {
+	b.UpdateMembers(mth, addrs)
+	return nil
+}
+
+// P2CBalancer 随机挑选两台服务器，选择代价 (未完成请求数+1)*EWMA延迟 较低的一台
+type P2CBalancer struct {
+	loadTracker
+	r *rand.Rand
+}
+
+var (
+	_ Balancer        = (*P2CBalancer)(nil)
+	_ Feedback        = (*P2CBalancer)(nil)
+	_ MembershipAware = (*P2CBalancer)(nil)
+)
+
+func NewP2CBalancer() *P2CBalancer {
+	return &P2CBalancer{
+		loadTracker: newLoadTracker(),
+		r:           rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (l *addrLoad) cost() float64 {
+	return float64(l.inflight+1) * (l.ewma + 1)
+}
+
+func (b *P2CBalancer) Next(mth string, clientAddr string, addrs []string) string {
+	switch len(addrs) {
+	case 0:
+		return ""
+	case 1:
+		return addrs[0]
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := b.r.Intn(len(addrs))
+	j := b.r.Intn(len(addrs) - 1)
+	if j >= i {
+		j++
+	}
+	if b.load(mth, addrs[j]).cost() < b.load(mth, addrs[i]).cost() {
+		return addrs[j]
+	}
+	return addrs[i]
+}
+
+func (b *P2CBalancer) refresh(mth string, addrs []string) error {
+	b.UpdateMembers(mth, addrs)
+	return nil
+}
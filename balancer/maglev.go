@@ -0,0 +1,181 @@
+package balancer
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultMaglevTableSize 查找表大小，需为远大于服务器数的质数
+const DefaultMaglevTableSize = 65537
+
+// MaglevBalancer Maglev一致性哈希：按各服务器的排列轮流填充定长查找表，查找为O(1)，
+// 服务器增减时大部分键保持不变。权重决定每轮填充的槽位数
+type MaglevBalancer struct {
+	mu      sync.Mutex
+	size    uint32
+	weights map[string]int
+	version int                     // 权重变化时递增
+	tables  map[string]*maglevTable // 方法 -> 查找表
+}
+
+type maglevTable struct {
+	version int             // 构建时的权重版本
+	present map[string]bool // 构建时的服务器
+	slots   []string
+}
+
+var (
+	_ Balancer  = (*MaglevBalancer)(nil)
+	_ MetaAware = (*MaglevBalancer)(nil)
+)
+
+// NewMaglevBalancer size为查找表大小，不大于0时使用DefaultMaglevTableSize，
+// 不是质数时向上取最近的质数，否则排列无法遍历所有槽位
+func NewMaglevBalancer(size int) *MaglevBalancer {
+	if size <= 0 {
+		size = DefaultMaglevTableSize
+	}
+	return &MaglevBalancer{
+		size:    nextPrime(uint32(size)),
+		weights: map[string]int{},
+		tables:  map[string]*maglevTable{},
+	}
+}
+
+func (b *MaglevBalancer) UpdateMeta(addr string, meta map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w := parseWeight(meta)
+	if old, ok := b.weights[addr]; !ok || old != w {
+		b.weights[addr] = w
+		b.version++
+	}
+}
+
+func (b *MaglevBalancer) Next(mth string, clientAddr string, addrs []string) string {
+	if len(addrs) == 0 {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.refresh(mth, addrs); err != nil {
+		return ""
+	}
+	table := b.tables[mth]
+	return table.slots[XXHash([]byte(clientAddr))%b.size]
+}
+
+// 服务器或权重变化时重建查找表
+func (b *MaglevBalancer) refresh(mth string, addrs []string) error {
+	if table, ok := b.tables[mth]; ok && table.version == b.version && table.same(addrs) {
+		return nil
+	}
+	present := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		present[addr] = true
+	}
+	b.tables[mth] = &maglevTable{
+		version: b.version,
+		present: present,
+		slots:   b.populate(positiveWeights(b.weights, addrs)),
+	}
+	return nil
+}
+
+func (t *maglevTable) same(addrs []string) bool {
+	if len(addrs) != len(t.present) {
+		return false
+	}
+	for _, addr := range addrs {
+		if !t.present[addr] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *MaglevBalancer) populate(weights map[string]int) []string {
+	addrs := make([]string, 0, len(weights))
+	maxWeight := 0
+	for addr, w := range weights {
+		if w > 0 {
+			addrs = append(addrs, addr)
+		}
+		if w > maxWeight {
+			maxWeight = w
+		}
+	}
+	sort.Strings(addrs)
+	// 每台服务器的排列为 (offset + j*skip) % size，pos记录排列中的下一个位置
+	pos := make([]uint32, len(addrs))
+	skip := make([]uint32, len(addrs))
+	credit := make([]int, len(addrs))
+	for i, addr := range addrs {
+		pos[i] = xxHash32([]byte(addr), 0) % b.size
+		skip[i] = xxHash32([]byte(addr), 1)%(b.size-1) + 1
+	}
+	slots := make([]string, b.size)
+	filled := uint32(0)
+	for filled < b.size {
+		for i, addr := range addrs {
+			// 权重为最大权重一半的服务器每两轮才填充一次
+			credit[i] += weights[addr]
+			if credit[i] < maxWeight {
+				continue
+			}
+			credit[i] -= maxWeight
+			for {
+				slot := pos[i]
+				pos[i] = uint32((uint64(pos[i]) + uint64(skip[i])) % uint64(b.size))
+				if slots[slot] == "" {
+					slots[slot] = addr
+					filled++
+					break
+				}
+			}
+			if filled == b.size {
+				break
+			}
+		}
+	}
+	return slots
+}
+
+// 返回不小于n的最小质数
+func nextPrime(n uint32) uint32 {
+	if n <= 2 {
+		return 2
+	}
+	for ; ; n++ {
+		prime := true
+		for d := uint32(2); d*d <= n; d++ {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+// 返回addrs中各服务器的权重，全部为0时按1处理
+func positiveWeights(weights map[string]int, addrs []string) map[string]int {
+	res := make(map[string]int, len(addrs))
+	total := 0
+	for _, addr := range addrs {
+		w, ok := weights[addr]
+		if !ok {
+			w = 1
+		}
+		res[addr] = w
+		total += w
+	}
+	if total == 0 {
+		for _, addr := range addrs {
+			res[addr] = 1
+		}
+	}
+	return res
+}
@@ -0,0 +1,64 @@
+package balancer
+
+import (
+	"math"
+	"sync"
+)
+
+// RendezvousBalancer 最高随机权重（HRW）哈希：对每台服务器计算 weight / -ln(hash(key, addr))，选得分最高者。
+// 服务器增减时只有落在该服务器上的键会迁移，查找为O(n)
+type RendezvousBalancer struct {
+	mu      sync.Mutex
+	weights map[string]int
+}
+
+var (
+	_ Balancer  = (*RendezvousBalancer)(nil)
+	_ MetaAware = (*RendezvousBalancer)(nil)
+)
+
+func NewRendezvousBalancer() *RendezvousBalancer {
+	return &RendezvousBalancer{weights: map[string]int{}}
+}
+
+func (b *RendezvousBalancer) UpdateMeta(addr string, meta map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.weights[addr] = parseWeight(meta)
+}
+
+func (b *RendezvousBalancer) Next(mth string, clientAddr string, addrs []string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if target := b.highest(clientAddr, addrs, false); target != "" {
+		return target
+	}
+	return b.highest(clientAddr, addrs, true) // 权重全部为0
+}
+
+func (b *RendezvousBalancer) highest(key string, addrs []string, ignoreWeight bool) string {
+	var target string
+	best := math.Inf(-1)
+	buf := make([]byte, 0, 64)
+	for _, addr := range addrs {
+		w, ok := b.weights[addr]
+		if !ok || ignoreWeight {
+			w = 1
+		}
+		if w == 0 {
+			continue
+		}
+		buf = append(append(append(buf[:0], key...), '#'), addr...)
+		// 将32位哈希映射到(0,1)，权重越大得分越高
+		u := (float64(XXHash(buf)) + 0.5) / (math.MaxUint32 + 1)
+		score := float64(w) / -math.Log(u)
+		if score > best {
+			best, target = score, addr
+		}
+	}
+	return target
+}
+
+func (b *RendezvousBalancer) refresh(mth string, addrs []string) error {
+	return nil
+}
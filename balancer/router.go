@@ -0,0 +1,116 @@
+package balancer
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+// Rule 路由规则：对Method的调用中，调用元数据匹配Match的请求按Percent%的比例发往
+// 元数据匹配Subset的服务器，其余发往不匹配Subset的服务器。例如5%的流量进入灰度：
+//
+//	{"method": "Foo.Sum", "subset": {"canary": "true"}, "percent": 5}
+//
+// 带有 user-group=beta 元数据的调用全部进入灰度：
+//
+//	{"method": "Foo", "match": {"user-group": "beta"}, "subset": {"canary": "true"}, "percent": 100}
+type Rule struct {
+	Method  string            `json:"method"`          // "Service.Method"，或服务名表示该服务的所有方法
+	Match   map[string]string `json:"match,omitempty"` // 为空表示匹配所有调用
+	Subset  map[string]string `json:"subset"`
+	Percent float64           `json:"percent"` // 0~100
+}
+
+func (rule *Rule) Validate() error {
+	if rule.Method == "" {
+		return errors.New("rpc balancer: rule without method")
+	}
+	if len(rule.Subset) == 0 {
+		return errors.New("rpc balancer: rule without subset")
+	}
+	if rule.Percent < 0 || rule.Percent > 100 {
+		return errors.New("rpc balancer: rule percent out of range")
+	}
+	return nil
+}
+
+// AppliesTo 判断规则是否作用于该方法
+func (rule *Rule) AppliesTo(method string) bool {
+	if dot := strings.LastIndex(method, "."); dot >= 0 && rule.Method == method[:dot] {
+		return true
+	}
+	return rule.Method == method
+}
+
+func matchAll(want, got map[string]string) bool {
+	for k, v := range want {
+		if got[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Router 位于BalancerX之前，按规则从可用服务器中筛选出本次调用的候选服务器
+type Router struct {
+	mu    sync.Mutex
+	rules map[string][]Rule            // 方法 -> 规则，按顺序匹配第一条
+	meta  map[string]map[string]string // 服务器 -> 元数据
+	r     *rand.Rand
+}
+
+var _ MetaAware = (*Router)(nil)
+
+func NewRouter() *Router {
+	return &Router{
+		rules: map[string][]Rule{},
+		meta:  map[string]map[string]string{},
+		r:     rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// SetRules 替换某个方法的规则
+func (r *Router) SetRules(method string, rules []Rule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(rules) == 0 {
+		delete(r.rules, method)
+		return
+	}
+	r.rules[method] = rules
+}
+
+func (r *Router) UpdateMeta(addr string, meta map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.meta[addr] = meta
+}
+
+// Route 返回本次调用的候选服务器，md为调用元数据。选中的子集为空时返回addrs
+func (r *Router) Route(method string, md map[string]string, addrs []string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rule := range r.rules[method] {
+		if !matchAll(rule.Match, md) {
+			continue
+		}
+		var in, out []string
+		for _, addr := range addrs {
+			if matchAll(rule.Subset, r.meta[addr]) {
+				in = append(in, addr)
+			} else {
+				out = append(out, addr)
+			}
+		}
+		chosen := out
+		if r.r.Float64()*100 < rule.Percent {
+			chosen = in
+		}
+		if len(chosen) == 0 {
+			return addrs
+		}
+		return chosen
+	}
+	return addrs
+}
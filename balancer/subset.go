@@ -0,0 +1,32 @@
+package balancer
+
+import (
+	"math"
+	"sort"
+)
+
+// Subset 确定性子集（deterministic aperture）：服务器按地址哈希排序后均匀分布在[0,1)的环上，
+// 客户端按标识哈希得到环上的坐标，选取从该坐标起顺时针的size台服务器。
+// 同一客户端总是得到相同的子集，各服务器被选中的客户端数近似相同；
+// 服务器增减时窗口只平移一个位置，子集中至多两台服务器发生变化
+func Subset(clientID string, addrs []string, size int) []string {
+	if size <= 0 || len(addrs) <= size {
+		return addrs
+	}
+	sorted := append([]string(nil), addrs...)
+	hashes := make(map[string]uint32, len(sorted))
+	for _, addr := range sorted {
+		hashes[addr] = XXHash([]byte(addr))
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		hi, hj := hashes[sorted[i]], hashes[sorted[j]]
+		return hi < hj || hi == hj && sorted[i] < sorted[j]
+	})
+	coord := float64(XXHash([]byte(clientID))) / (math.MaxUint32 + 1)
+	start := int(coord * float64(len(sorted)))
+	subset := make([]string, size)
+	for i := range subset {
+		subset[i] = sorted[(start+i)%len(sorted)]
+	}
+	return subset
+}
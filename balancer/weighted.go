@@ -0,0 +1,86 @@
+package balancer
+
+import (
+	"strconv"
+	"sync"
+)
+
+// WeightedRoundRobinBalancer nginx式的平滑加权轮询，权重取自服务器元数据中的weight，
+// 未上报或无法解析时按1处理，权重为0的服务器只在其他服务器都不可用时才会被选中
+type WeightedRoundRobinBalancer struct {
+	mu      sync.Mutex
+	weights map[string]int            // 服务器 -> 权重
+	current map[string]map[string]int // 方法 -> 服务器 -> 当前权重
+}
+
+var (
+	_ Balancer  = (*WeightedRoundRobinBalancer)(nil)
+	_ MetaAware = (*WeightedRoundRobinBalancer)(nil)
+)
+
+func NewWeightedRoundRobinBalancer() *WeightedRoundRobinBalancer {
+	return &WeightedRoundRobinBalancer{
+		weights: map[string]int{},
+		current: map[string]map[string]int{},
+	}
+}
+
+func (b *WeightedRoundRobinBalancer) UpdateMeta(addr string, meta map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.weights[addr] = parseWeight(meta)
+}
+
+func parseWeight(meta map[string]string) int {
+	w, err := strconv.Atoi(meta[MetaWeight])
+	if err != nil || w < 0 {
+		return 1
+	}
+	return w
+}
+
+func (b *WeightedRoundRobinBalancer) weight(addr string) int {
+	if w, ok := b.weights[addr]; ok {
+		return w
+	}
+	return 1
+}
+
+func (b *WeightedRoundRobinBalancer) Next(mth string, clientAddr string, addrs []string) string {
+	if len(addrs) == 0 {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cur, ok := b.current[mth]
+	if !ok {
+		cur = map[string]int{}
+		b.current[mth] = cur
+	}
+	present := make(map[string]bool, len(addrs))
+	best, total := "", 0
+	for _, addr := range addrs {
+		present[addr] = true
+		w := b.weight(addr)
+		cur[addr] += w
+		total += w
+		if best == "" || cur[addr] > cur[best] {
+			best = addr
+		}
+	}
+	// 已下线的服务器不再参与计算
+	for addr := range cur {
+		if !present[addr] {
+			delete(cur, addr)
+		}
+	}
+	cur[best] -= total
+	return best
+}
+
+func (b *WeightedRoundRobinBalancer) refresh(mth string, addrs []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.current, mth)
+	return nil
+}
@@ -0,0 +1,149 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSpillThreshold 本机房健康容量低于该比例时，流量溢出到其他机房
+	DefaultSpillThreshold = 0.5
+	// 连续失败maxFailures次的服务器在ejectDuration内视为不健康
+	maxFailures   = 3
+	ejectDuration = 10 * time.Second
+)
+
+// ZoneAwareBalancer 优先选择与调用方同机房（元数据zone）的服务器，跨机房流量只在本机房
+// 健康容量（按weight计）占比低于阈值时才会出现。候选服务器交给inner做最终选择
+type ZoneAwareBalancer struct {
+	mu        sync.Mutex
+	zone      string
+	threshold float64
+	inner     Balancer
+	zones     map[string]string // 服务器 -> 机房
+	weights   map[string]int
+	failures  map[string]int       // 连续失败次数
+	ejected   map[string]time.Time // 不健康的服务器 -> 恢复时间
+}
+
+var (
+	_ Balancer        = (*ZoneAwareBalancer)(nil)
+	_ MetaAware       = (*ZoneAwareBalancer)(nil)
+	_ Feedback        = (*ZoneAwareBalancer)(nil)
+	_ MembershipAware = (*ZoneAwareBalancer)(nil)
+)
+
+// NewZoneAwareBalancer zone为调用方所在机房，为空时不区分机房；inner为空时使用轮询
+func NewZoneAwareBalancer(zone string, threshold float64, inner Balancer) *ZoneAwareBalancer {
+	if inner == nil {
+		inner = NewRoundRobinBalancer()
+	}
+	return &ZoneAwareBalancer{
+		zone:      zone,
+		threshold: threshold,
+		inner:     inner,
+		zones:     map[string]string{},
+		weights:   map[string]int{},
+		failures:  map[string]int{},
+		ejected:   map[string]time.Time{},
+	}
+}
+
+// SetZone 修改调用方所在机房与溢出阈值，已记录的健康状态保持不变
+func (b *ZoneAwareBalancer) SetZone(zone string, threshold float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.zone, b.threshold = zone, threshold
+}
+
+func (b *ZoneAwareBalancer) UpdateMeta(addr string, meta map[string]string) {
+	b.mu.Lock()
+	b.zones[addr] = meta[MetaZone]
+	b.weights[addr] = parseWeight(meta)
+	b.mu.Unlock()
+	if m, ok := b.inner.(MetaAware); ok {
+		m.UpdateMeta(addr, meta)
+	}
+}
+
+func (b *ZoneAwareBalancer) UpdateMembers(mth string, addrs []string) {
+	if m, ok := b.inner.(MembershipAware); ok {
+		m.UpdateMembers(mth, addrs)
+	}
+}
+
+func (b *ZoneAwareBalancer) Next(mth string, clientAddr string, addrs []string) string {
+	return b.inner.Next(mth, clientAddr, b.candidates(addrs))
+}
+
+// 本机房健康容量充足时只返回本机房的健康服务器，否则返回所有健康服务器；
+// 全部不健康时返回addrs，避免因误判而无服务器可用
+func (b *ZoneAwareBalancer) candidates(addrs []string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	var local, healthy []string
+	localWeight, localHealthyWeight := 0, 0
+	for _, addr := range addrs {
+		ok := !now.Before(b.ejected[addr])
+		if ok {
+			healthy = append(healthy, addr)
+		}
+		if b.zone == "" || b.zones[addr] != b.zone {
+			continue
+		}
+		w := b.weight(addr)
+		localWeight += w
+		if ok {
+			local = append(local, addr)
+			localHealthyWeight += w
+		}
+	}
+	if b.zone == "" {
+		local, localWeight, localHealthyWeight = healthy, 1, 1
+	}
+	switch {
+	// 本机房服务器的权重全部为0时没有可用容量，同样需要溢出
+	case len(local) > 0 && localWeight > 0 && float64(localHealthyWeight) >= b.threshold*float64(localWeight):
+		return local
+	case len(healthy) > 0:
+		return healthy
+	default:
+		return addrs
+	}
+}
+
+func (b *ZoneAwareBalancer) weight(addr string) int {
+	if w, ok := b.weights[addr]; ok {
+		return w
+	}
+	return 1
+}
+
+func (b *ZoneAwareBalancer) Start(mth, addr string) {
+	if f, ok := b.inner.(Feedback); ok {
+		f.Start(mth, addr)
+	}
+}
+
+func (b *ZoneAwareBalancer) Done(mth, addr string, latency time.Duration, err error) {
+	b.mu.Lock()
+	if err == nil {
+		delete(b.failures, addr)
+		delete(b.ejected, addr)
+	} else {
+		b.failures[addr]++
+		if b.failures[addr] >= maxFailures {
+			b.failures[addr] = 0
+			b.ejected[addr] = time.Now().Add(ejectDuration)
+		}
+	}
+	b.mu.Unlock()
+	if f, ok := b.inner.(Feedback); ok {
+		f.Done(mth, addr, latency, err)
+	}
+}
+
+func (b *ZoneAwareBalancer) refresh(mth string, addrs []string) error {
+	return nil
+}
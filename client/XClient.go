@@ -2,35 +2,136 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 	"time"
+	"zrpc/balancer"
+	"zrpc/registry"
 	"zrpc/service"
 )
 
 type XClient struct {
-	mode           string
-	opt            *service.Option
-	mu             sync.Mutex
-	clients        map[string]*Client
-	timeout        time.Duration
-	registerClient *http.Client
-	registerAddr   string
+	mode       string
+	opt        *service.Option
+	mu         sync.Mutex
+	clients    map[string]*Client
+	timeout    time.Duration
+	endpoints  *registry.Endpoints // 注册中心，多个地址时自动切换
+	d          registry.Discovery  // 非nil时代替注册中心发现服务器
+	versions   map[string]string   // 服务名 -> 版本约束
+	bx         *balancer.BalancerX
+	router     *balancer.Router // 按注册中心下发的规则筛选候选服务器
+	subsetSize int              // 大于0时只连接确定性子集中的服务器
+	id         string           // 客户端标识，确定性子集与一致性哈希等策略以此作为键
+}
+
+type routingKeyCtx struct{}
+
+// WithRoutingKey 为本次调用指定路由键（如用户ID），一致性哈希等策略将以此代替客户端标识，
+// 使同一实体的请求落在同一台服务器上
+func WithRoutingKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, routingKeyCtx{}, key)
+}
+
+func routingKey(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(routingKeyCtx{}).(string)
+	return key, ok && key != ""
+}
+
+type metadataCtx struct{}
+
+// WithMetadata 为本次调用附加元数据，注册中心下发的路由规则据此选择服务器子集，
+// 如 WithMetadata(ctx, "user-group", "beta")
+func WithMetadata(ctx context.Context, key, value string) context.Context {
+	md := map[string]string{key: value}
+	for k, v := range metadata(ctx) {
+		if k != key {
+			md[k] = v
+		}
+	}
+	return context.WithValue(ctx, metadataCtx{}, md)
+}
+
+func metadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataCtx{}).(map[string]string)
+	return md
 }
 
 var _ io.Closer = (*XClient)(nil)
 
 func NewXClient(registerAddr string, mode string, opt *service.Option, dialTimeout time.Duration) *XClient {
 	return &XClient{
-		mode:           mode,
-		opt:            opt,
-		clients:        make(map[string]*Client),
-		timeout:        dialTimeout,
-		registerClient: &http.Client{},
-		registerAddr:   registerAddr,
+		mode:      mode,
+		opt:       opt,
+		clients:   make(map[string]*Client),
+		timeout:   dialTimeout,
+		endpoints: registry.NewEndpoints(registerAddr),
+		versions:  make(map[string]string),
+		bx:        balancer.NewBalancerX(),
+		router:    balancer.NewRouter(),
+		id:        newClientID(),
+	}
+}
+
+// NewXClientWithDiscovery 使用任意Discovery（gossip、DNS SRV、本地文件等）代替注册中心，
+// 实现了registry.ServiceDiscovery时按方法发现服务器，否则所有方法使用GetAll的结果
+func NewXClientWithDiscovery(d registry.Discovery, mode string, opt *service.Option, dialTimeout time.Duration) *XClient {
+	xc := NewXClient("", mode, opt, dialTimeout)
+	xc.d = d
+	return xc
+}
+
+// 默认以主机名作为客户端标识，进程重启后子集不变；取不到主机名时退化为进程号
+func newClientID() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return fmt.Sprintf("pid-%d", os.Getpid())
+}
+
+// SetClientID 指定客户端标识，默认为主机名。同一主机上运行多个实例时应为每个实例指定不同且
+// 重启后不变的标识（如实例名），以便确定性子集稳定且均匀
+func (xc *XClient) SetClientID(id string) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.id = id
+}
+
+// SetVersion 限定服务的版本，如 "1.2.3"、"^1.2"、">=1.2.0 <2.0.0"，注册中心只返回满足约束的服务器
+func (xc *XClient) SetVersion(service, constraint string) error {
+	if _, err := registry.ParseConstraint(constraint); err != nil {
+		return err
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.versions[service] = constraint
+	return nil
+}
+
+// SetSubsetSize 大规模集群中限制每个客户端连接的服务器数：按客户端标识确定性地选出size台服务器，
+// 只有这些服务器交给负载均衡器，0表示不限制
+func (xc *XClient) SetSubsetSize(size int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.subsetSize = size
+}
+
+// RegisterBalancer 为该客户端注册自定义负载均衡策略，创建客户端时以name作为strategy即可使用
+func (xc *XClient) RegisterBalancer(name string, b balancer.Balancer) {
+	xc.bx.Register(name, b)
+}
+
+// SetZone 指定调用方所在机房，"ZoneAware"策略将优先选择元数据zone相同的服务器，
+// 本机房健康容量低于threshold时溢出到其他机房。可随时调用，不会丢失已记录的服务器健康状态
+func (xc *XClient) SetZone(zone string, threshold float64) {
+	if z, ok := xc.bx.Balancer("ZoneAware").(*balancer.ZoneAwareBalancer); ok {
+		z.SetZone(zone, threshold)
 	}
 }
 
@@ -67,6 +168,9 @@ func (xc *XClient) dial(rpcAddr string) (*Client, error) {
 			return nil, err
 		}
 		client, err = Dial(addr[0], addr[1], xc.timeout, xc.opt)
+		if err != nil {
+			return nil, err
+		}
 		xc.clients[rpcAddr] = client
 	}
 	return client, nil
@@ -80,30 +184,150 @@ func (xc *XClient) call(rpcAddr string, serviceMethod string, args, reply interf
 	return client.Call(serviceMethod, args, reply, ctx)
 }
 
+// Discover 返回本地负载均衡器按mode选出的服务器，与Call的选择方式相同
 func (xc *XClient) Discover(serviceMethod string) (string, error) {
-	req, err := http.NewRequest(http.MethodGet, xc.registerAddr, nil)
+	return xc.DiscoverContext(context.Background(), serviceMethod)
+}
+
+// DiscoverContext 与Discover相同，ctx中的路由键与元数据同样参与选择，
+// 返回的服务器即以该ctx调用CallContext时使用的服务器
+func (xc *XClient) DiscoverContext(ctx context.Context, serviceMethod string) (string, error) {
+	return xc.pick(ctx, serviceMethod)
+}
+
+// DiscoverAll 返回提供该方法的所有可用服务器及其元数据
+func (xc *XClient) DiscoverAll(serviceMethod string) ([]registry.ServerItem, error) {
+	return xc.discover(serviceMethod)
+}
+
+// 在客户端本地做负载均衡：依次经过路由规则、确定性子集与负载均衡器，
+// 选择只在这里进行，以便将调用结果反馈给同一个负载均衡器
+func (xc *XClient) pick(ctx context.Context, serviceMethod string) (string, error) {
+	items, err := xc.DiscoverAll(serviceMethod)
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("X-Zrpc-Services", serviceMethod)
-	req.Header.Set("X-Zrpc-Mode", xc.mode)
-	resp, err := xc.registerClient.Do(req)
-	if err != nil {
-		return "", err
+	addrs := make([]string, 0, len(items))
+	for _, item := range items {
+		xc.bx.UpdateMeta(item.Addr, item.Meta)
+		xc.router.UpdateMeta(item.Addr, item.Meta)
+		addrs = append(addrs, item.Addr)
+	}
+	// 以筛选前的完整列表清理已下线服务器的负载统计
+	xc.bx.UpdateMembers(serviceMethod, addrs)
+	addrs = xc.router.Route(serviceMethod, metadata(ctx), addrs)
+	xc.mu.Lock()
+	subsetSize, id := xc.subsetSize, xc.id
+	xc.mu.Unlock()
+	addrs = balancer.Subset(id, addrs, subsetSize)
+	key := id
+	if k, ok := routingKey(ctx); ok {
+		key = k
 	}
-	return resp.Header.Get("X-Zrpc-Servers"), nil
+	rpcAddr := xc.bx.Next(xc.mode, serviceMethod, key, addrs)
+	if rpcAddr == "" {
+		return "", errors.New("rpc client: no available server for " + serviceMethod)
+	}
+	return rpcAddr, nil
 }
 
-func (xc *XClient) Call(serviceMethod string, args, reply interface{}, timeout time.Duration) error {
-	rpcAddr, err := xc.Discover(serviceMethod)
+func (xc *XClient) discover(serviceMethod string) ([]registry.ServerItem, error) {
+	xc.mu.Lock()
+	var version string
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		version = xc.versions[serviceMethod[:dot]]
+	}
+	xc.mu.Unlock()
+	if xc.d != nil {
+		return xc.discoverFrom(serviceMethod, version)
+	}
+	resp, err := xc.endpoints.Do(func(addr string) (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, addr, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Zrpc-Services", serviceMethod)
+		if version != "" {
+			req.Header.Set("X-Zrpc-Version", version)
+		}
+		return req, nil
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("rpc client: discover failed: " + resp.Status)
+	}
+	var items []registry.ServerItem
+	if err = json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, err
+	}
+	var rules []balancer.Rule
+	if raw := resp.Header.Get("X-Zrpc-Rules"); raw != "" {
+		if err = json.Unmarshal([]byte(raw), &rules); err != nil {
+			return nil, err
+		}
+	}
+	xc.router.SetRules(serviceMethod, rules)
+	return items, nil
+}
+
+// 从Discovery获取服务器，版本约束在客户端本地过滤，路由规则仅由注册中心下发
+func (xc *XClient) discoverFrom(serviceMethod, version string) ([]registry.ServerItem, error) {
+	var items []registry.ServerItem
+	if sd, ok := xc.d.(registry.ServiceDiscovery); ok {
+		var err error
+		if items, err = sd.Servers(serviceMethod); err != nil {
+			return nil, err
+		}
+	} else {
+		servers, err := xc.d.GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range servers {
+			items = append(items, registry.ServerItem{Addr: addr})
+		}
+	}
+	if version != "" {
+		constraint, err := registry.ParseConstraint(version)
+		if err != nil {
+			return nil, err
+		}
+		svc := serviceMethod[:strings.LastIndex(serviceMethod, ".")]
+		var matched []registry.ServerItem
+		for _, item := range items {
+			if constraint.Check(item.Version(svc)) {
+				matched = append(matched, item)
+			}
+		}
+		items = matched
 	}
+	return items, nil
+}
+
+func (xc *XClient) Call(serviceMethod string, args, reply interface{}, timeout time.Duration) error {
 	ctx := context.Background()
 	if timeout != 0 {
-		ctx, _ = context.WithTimeout(context.Background(), timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+	}
+	return xc.CallContext(ctx, serviceMethod, args, reply)
+}
+
+// CallContext 与Call相同，超时与取消由ctx控制
+func (xc *XClient) CallContext(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	rpcAddr, err := xc.pick(ctx, serviceMethod)
+	if err != nil {
+		return err
 	}
-	return xc.call(rpcAddr, serviceMethod, args, reply, ctx)
+	xc.bx.Start(xc.mode, serviceMethod, rpcAddr)
+	start := time.Now()
+	err = xc.call(rpcAddr, serviceMethod, args, reply, ctx)
+	xc.bx.Done(xc.mode, serviceMethod, rpcAddr, time.Since(start), err)
+	return err
 }
 
 //func (xc *XClient) Broadcast(serviceMethod string, args, reply interface{}, timeout time.Duration) error {
@@ -10,10 +10,12 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 	"zrpc/codec"
 	"zrpc/service"
+	"zrpc/transport"
 )
 
 type Call struct {
@@ -62,11 +64,11 @@ func (client *Client) IsAvailable() bool {
 }
 
 func (client *Client) registerCall(call *Call) (uint64, error) {
-	if !client.IsAvailable() {
-		return 0, ErrClosing
-	}
 	client.mu.Lock()
 	defer client.mu.Unlock()
+	if client.closing || client.shutdown {
+		return 0, ErrClosing
+	}
 	call.Seq = client.seq
 	client.seq++
 	client.pending.Store(call.Seq, call)
@@ -89,7 +91,8 @@ func (client *Client) terminateCalls(err error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	client.shutdown = true
-	client.pending.Range(func(_, value interface{}) bool {
+	client.pending.Range(func(seq, value interface{}) bool {
+		client.pending.Delete(seq)
 		call, _ := value.(*Call)
 		call.Error = err
 		call.done()
@@ -109,8 +112,8 @@ func (client *Client) receive() {
 		case call == nil:
 			err = client.cc.ReadBody(nil)
 		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
-			err = client.cc.ReadHeader(nil)
+			call.Error = service.ParseError(h.Error)
+			err = client.cc.ReadBody(nil)
 			call.done()
 		default:
 			err = client.cc.ReadBody(call.Reply)
@@ -150,6 +153,11 @@ func NewHTTPClient(conn net.Conn, option *service.Option) (*Client, error) {
 	return nil, err
 }
 
+// NewNetRPCClient 以net/rpc的协议与服务端通信，不发送option，可调用标准库rpc.Server
+func NewNetRPCClient(conn net.Conn, opt *service.Option) (*Client, error) {
+	return newClientCodec(codec.NewNetRPCCodec(conn), opt), nil
+}
+
 func newClientCodec(cc codec.Codec, opt *service.Option) *Client {
 	client := &Client{
 		seq:     1,
@@ -176,21 +184,40 @@ func parseOptions(opts ...*service.Option) (*service.Option, error) {
 	return opt, nil
 }
 
+// NetRPCNetwork 以net/rpc协议通过tcp连接，地址形如 "netrpc@host:port"
+const NetRPCNetwork = "netrpc"
+
 type newClientFunc func(conn net.Conn, opt *service.Option) (*Client, error)
 
 func Dial(network, address string, timeout time.Duration, opts ...*service.Option) (client *Client, err error) {
 	var f newClientFunc
-	if network == "http" {
+	switch network {
+	case "http":
 		f = NewHTTPClient
 		network = "tcp"
-	} else {
+	case NetRPCNetwork:
+		f = NewNetRPCClient
+		network = "tcp"
+	default:
 		f = NewClient
 	}
+	return dialTimeout(f, network, address, timeout, opts...)
+}
+
+func dialTimeout(f newClientFunc, network, address string, timeout time.Duration, opts ...*service.Option) (client *Client, err error) {
 	opt, err := parseOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialTimeout(network, address, timeout)
+	var conn net.Conn
+	switch network {
+	case transport.MemNetwork:
+		conn, err = transport.DialMem(address, timeout)
+	case transport.WSNetwork:
+		conn, err = transport.DialWebSocket(wsURL(address), timeout)
+	default:
+		conn, err = net.DialTimeout(network, address, timeout)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -202,26 +229,45 @@ func Dial(network, address string, timeout time.Duration, opts ...*service.Optio
 	if timeout == 0 {
 		return f(conn, opt)
 	}
-	success := make(chan bool, 1) // 为了避免泄露
+	type result struct {
+		client *Client
+		err    error
+	}
+	ch := make(chan result, 1) // 带缓冲，超时返回后协程不会泄露
 	go func() {
-		client, err = f(conn, opt)
-		success <- true
+		c, err := f(conn, opt)
+		ch <- result{c, err}
 	}()
 	select {
 	case <-time.After(timeout):
+		// 握手完成得太晚时关闭创建出的客户端
+		go func() {
+			if res := <-ch; res.client != nil {
+				_ = res.client.Close()
+			}
+		}()
 		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", timeout)
-	case <-success:
-		return client, err
+	case res := <-ch:
+		return res.client, res.err
 	}
 }
 
-func (client *Client) send(call *Call) {
+// 地址未带路径时使用服务端默认的WebSocket路径
+func wsURL(address string) string {
+	if strings.HasPrefix(address, "ws://") || strings.HasPrefix(address, "wss://") {
+		return address
+	}
+	if !strings.Contains(address, "/") {
+		address += service.DefaultWSPath
+	}
+	return "ws://" + address
+}
+
+func (client *Client) send(call *Call, seq uint64) {
 	client.sending.Lock()
 	defer client.sending.Unlock()
-	seq, err := client.registerCall(call)
-	if err != nil {
-		call.Error = err
-		call.done()
+	// 发送前已超时取消或连接已终止
+	if _, ok := client.pending.Load(seq); !ok {
 		return
 	}
 
@@ -250,7 +296,14 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 		Reply:         reply,
 		Done:          done,
 	}
-	go client.send(call)
+	// 在返回前注册，调用方超时时可以按序号取消
+	seq, err := client.registerCall(call)
+	if err != nil {
+		call.Error = err
+		call.done()
+		return call
+	}
+	go client.send(call, seq)
 	return call
 }
 
@@ -1,12 +1,23 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+	"zrpc/balancer"
+	"zrpc/registry"
 	"zrpc/service"
+	"zrpc/transport"
 )
 
 func _assert(condition bool, msg string, v ...interface{}) {
@@ -18,18 +29,18 @@ func _assert(condition bool, msg string, v ...interface{}) {
 func TestClient_Dial(t *testing.T) {
 	t.Parallel()
 	l, _ := net.Listen("tcp", ":0")
-	_ = func(conn net.Conn, opt *service.Option) (client *Client, err error) {
+	f := func(conn net.Conn, opt *service.Option) (client *Client, err error) {
 		_ = conn.Close()
 		time.Sleep(time.Second * 2)
 		return nil, nil
 	}
 	t.Run("timeout", func(t *testing.T) {
-		_, err := Dial("tcp", l.Addr().String(), time.Second)
+		_, err := dialTimeout(f, "tcp", l.Addr().String(), time.Second)
 		_assert(err != nil && strings.Contains(err.Error(), "connect timeout"), "expect a timeout error")
 	})
 
 	t.Run("0", func(t *testing.T) {
-		_, err := Dial("tcp", l.Addr().String(), 3*time.Second)
+		_, err := dialTimeout(f, "tcp", l.Addr().String(), 0)
 		_assert(err == nil, "0 means no limit")
 	})
 }
@@ -40,31 +51,347 @@ func (b Bar) Timeout(argv int, reply *int) error {
 	time.Sleep(time.Second * 2)
 	return nil
 }
-func startServer(addr chan string) {
+
+func (b Bar) Double(argv int, reply *int) error {
+	*reply = argv * 2
+	return nil
+}
+
+// startServer 以name启动一个进程内服务端，测试结束时关闭监听器，以便 -count=N 重复运行时复用name
+func startServer(t *testing.T, name string) *service.Server {
 	var b Bar
-	_ = service.Register(&b)
-	l, _ := net.Listen("tcp", ":0")
-	addr <- l.Addr().String()
-	service.Listen(l)
-}
-
-//func TestClient_Call(t *testing.T) {
-//	t.Parallel()
-//	addrch := make(chan string)
-//	go startServer(addrch)
-//	addr := <-addrch
-//	time.Sleep(time.Second)
-//	t.Run("client timeout", func(t *testing.T) {
-//		client, _ := Dial("tcp", addr, 0)
-//		var reply int
-//		err := client.Call("Bar.Timeout", 1, &reply, 1*time.Second)
-//		_assert(err != nil && strings.Contains(err.Error(), "call timeout"), "expect a timeout error")
-//	})
-//
-//	t.Run("client timeout", func(t *testing.T) {
-//		client, _ := Dial("tcp", addr, 0, &service.Option{MaxCallTime: 1 * time.Second})
-//		var reply int
-//		err := client.Call("Bar.Timeout", 1, &reply, 0)
-//		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
-//	})
-//}
+	server := service.NewServer("", transport.MemNetwork+"@"+name)
+	_ = server.Register(&b)
+	l, err := transport.ListenMem(name)
+	if err != nil {
+		t.Fatal("listen mem:", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go server.Listen(l, 0)
+	return server
+}
+
+// startServers 启动一组进程内服务端并注册到registryAddr，method的应答为服务端的名字；
+// setup非nil时在首次心跳前调用，用于设置版本、元数据等。测试结束时停止心跳并关闭监听器
+func startServers(t *testing.T, registryAddr, method string, names []string, setup func(name string, server *service.Server)) {
+	for _, name := range names {
+		name := name
+		server := service.NewServer(registryAddr, transport.MemNetwork+"@"+name)
+		if err := service.RegisterFunc(server, method, func(_ int, reply *string) error {
+			*reply = name
+			return nil
+		}); err != nil {
+			t.Fatal("register:", err)
+		}
+		if setup != nil {
+			setup(name, server)
+		}
+		l, err := transport.ListenMem(name)
+		if err != nil {
+			t.Fatal("listen mem:", err)
+		}
+		t.Cleanup(func() {
+			_ = server.Close()
+			_ = l.Close()
+		})
+		server.Heartbeat(0) // 先完成注册，避免与Listen中的心跳竞争
+		go server.Listen(l, 0)
+	}
+}
+
+func TestClient_Call(t *testing.T) {
+	t.Parallel()
+	startServer(t, "bar-call")
+	t.Run("client timeout", func(t *testing.T) {
+		client, _ := Dial(transport.MemNetwork, "bar-call", 0)
+		var reply int
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err := client.Call("Bar.Timeout", 1, &reply, ctx)
+		_assert(err != nil && strings.Contains(err.Error(), "call timeout"), "expect a timeout error")
+	})
+
+	t.Run("server handle timeout", func(t *testing.T) {
+		client, _ := Dial(transport.MemNetwork, "bar-call", 0, &service.Option{MaxCallTime: 1 * time.Second})
+		var reply int
+		err := client.Call("Bar.Timeout", 1, &reply, context.Background())
+		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		client, _ := Dial(transport.MemNetwork, "bar-call", 0)
+		defer func() { _ = client.Close() }()
+		var reply int
+		for _, method := range []string{"Bar.Triple", "Baz.Double"} {
+			err := client.Call(method, 1, &reply, context.Background())
+			_assert(errors.Is(err, service.ErrNotFound), "expect ErrNotFound for %s, got %v", method, err)
+		}
+	})
+}
+
+func TestXClient_Mem(t *testing.T) {
+	t.Parallel()
+	startServer(t, "bar-xclient")
+	r := registry.New(registry.DefaultTimeout)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	server := service.NewServer(ts.URL, transport.MemNetwork+"@bar-xclient")
+	_ = server.Register(new(Bar))
+	server.Heartbeat(0)
+	defer func() { _ = server.Close() }()
+
+	xc := NewXClient(ts.URL, "RoundRobin", nil, 0)
+	defer func() { _ = xc.Close() }()
+	var reply int
+	err := xc.Call("Bar.Double", 21, &reply, time.Second)
+	_assert(err == nil && reply == 42, "expect 42, got %d, err %v", reply, err)
+	reply, err = CallTyped[int, int](context.Background(), xc, "Bar.Double", 4)
+	_assert(err == nil && reply == 8, "expect 8, got %d, err %v", reply, err)
+
+	// 配置多个注册中心时跳过不可用的节点
+	failover := NewXClient("http://127.0.0.1:1/registry,"+ts.URL, "RoundRobin", nil, 0)
+	defer func() { _ = failover.Close() }()
+	reply, err = CallTyped[int, int](context.Background(), failover, "Bar.Double", 5)
+	_assert(err == nil && reply == 10, "expect failover to the live registry, got %d, err %v", reply, err)
+}
+
+func TestClient_WebSocket(t *testing.T) {
+	t.Parallel()
+	server := service.NewServer("", "")
+	_ = server.Register(new(Bar))
+	mux := http.NewServeMux()
+	mux.HandleFunc(service.DefaultWSPath, server.ServeWebSocket)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	client, err := Dial(transport.WSNetwork, addr, time.Second)
+	_assert(err == nil, "dial websocket: %v", err)
+	defer func() { _ = client.Close() }()
+	for i := 0; i < 3; i++ {
+		var reply int
+		err = client.Call("Bar.Double", i, &reply, context.Background())
+		_assert(err == nil && reply == 2*i, "expect %d, got %d, err %v", 2*i, reply, err)
+	}
+}
+
+func TestClient_NetRPC(t *testing.T) {
+	t.Parallel()
+	t.Run("zrpc client to net/rpc server", func(t *testing.T) {
+		rs := rpc.NewServer()
+		_ = rs.Register(new(Bar))
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer func() { _ = l.Close() }()
+		go rs.Accept(l)
+
+		client, err := Dial(NetRPCNetwork, l.Addr().String(), time.Second)
+		_assert(err == nil, "dial net/rpc server: %v", err)
+		defer func() { _ = client.Close() }()
+		var reply int
+		err = client.Call("Bar.Double", 4, &reply, context.Background())
+		_assert(err == nil && reply == 8, "expect 8, got %d, err %v", reply, err)
+		err = client.Call("Bar.Triple", 4, &reply, context.Background())
+		_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect method error, got %v", err)
+	})
+
+	t.Run("net/rpc client to zrpc server", func(t *testing.T) {
+		server := service.NewServer("", "")
+		_ = server.Register(new(Bar))
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer func() { _ = l.Close() }()
+		go server.ListenNetRPC(l)
+
+		client, err := rpc.Dial("tcp", l.Addr().String())
+		_assert(err == nil, "dial zrpc server: %v", err)
+		defer func() { _ = client.Close() }()
+		var reply int
+		err = client.Call("Bar.Double", 5, &reply)
+		_assert(err == nil && reply == 10, "expect 10, got %d, err %v", reply, err)
+		err = client.Call("Bar.Triple", 5, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect method error, got %v", err)
+	})
+}
+
+func TestXClient_Version(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(registry.New(registry.DefaultTimeout))
+	defer ts.Close()
+	startServers(t, ts.URL, "Ver.Get", []string{"ver-1.4.0", "ver-2.1.0"}, func(name string, server *service.Server) {
+		_ = server.SetVersion("Ver", strings.TrimPrefix(name, "ver-"))
+	})
+
+	xc := NewXClient(ts.URL, "RoundRobin", nil, 0)
+	defer func() { _ = xc.Close() }()
+	_assert(xc.SetVersion("Ver", ">=x") != nil, "expect invalid constraint error")
+	for constraint, want := range map[string]string{"^2": "ver-2.1.0", "1.x": "ver-1.4.0", "<2.0.0": "ver-1.4.0"} {
+		_ = xc.SetVersion("Ver", constraint)
+		for i := 0; i < 4; i++ {
+			reply, err := CallTyped[int, string](context.Background(), xc, "Ver.Get", 0)
+			_assert(err == nil && reply == want, "%s: expect %s, got %s, err %v", constraint, want, reply, err)
+		}
+	}
+	_ = xc.SetVersion("Ver", "3")
+	_, err := CallTyped[int, string](context.Background(), xc, "Ver.Get", 0)
+	_assert(err != nil && strings.Contains(err.Error(), "no available server"), "expect no server, got %v", err)
+}
+
+func TestXClient_RoutingKey(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(registry.New(registry.DefaultTimeout))
+	defer ts.Close()
+	startServers(t, ts.URL, "Who.Am", []string{"key-0", "key-1", "key-2"}, nil)
+
+	xc := NewXClient(ts.URL, "ConsistentHash", nil, 0)
+	defer func() { _ = xc.Close() }()
+	servers := map[string]bool{}
+	for user := 0; user < 20; user++ {
+		ctx := WithRoutingKey(context.Background(), fmt.Sprintf("user-%d", user))
+		first, err := CallTyped[int, string](ctx, xc, "Who.Am", 0)
+		_assert(err == nil, "call error: %v", err)
+		for i := 0; i < 3; i++ {
+			again, _ := CallTyped[int, string](ctx, xc, "Who.Am", 0)
+			_assert(again == first, "expect the same server for the same key")
+		}
+		// DiscoverContext按同一路由键选出调用实际使用的服务器
+		target, err := xc.DiscoverContext(ctx, "Who.Am")
+		_assert(err == nil && target == transport.MemNetwork+"@"+first, "expect discover to agree with call, got %s and %s, err %v", target, first, err)
+		servers[first] = true
+	}
+	_assert(len(servers) > 1, "expect different keys to spread over servers, got %v", servers)
+}
+
+func TestXClient_Canary(t *testing.T) {
+	t.Parallel()
+	r := registry.New(registry.DefaultTimeout)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	startServers(t, ts.URL, "Rel.Name", []string{"canary-test-stable", "canary-test-canary"}, func(name string, server *service.Server) {
+		if name == "canary-test-canary" {
+			server.SetMetadata("canary", "true")
+		}
+	})
+
+	xc := NewXClient(ts.URL, "RoundRobin", nil, 0)
+	defer func() { _ = xc.Close() }()
+	call := func(ctx context.Context) string {
+		reply, err := CallTyped[int, string](ctx, xc, "Rel.Name", 0)
+		_assert(err == nil, "call error: %v", err)
+		return reply
+	}
+	// 规则在运行时更新，无需重建客户端
+	_ = r.SetRules([]balancer.Rule{
+		{Method: "Rel", Match: map[string]string{"user-group": "beta"}, Subset: map[string]string{"canary": "true"}, Percent: 100},
+		{Method: "Rel", Subset: map[string]string{"canary": "true"}, Percent: 0},
+	})
+	beta := WithMetadata(context.Background(), "user-group", "beta")
+	for i := 0; i < 4; i++ {
+		_assert(call(context.Background()) == "canary-test-stable", "expect stable server for normal users")
+		_assert(call(beta) == "canary-test-canary", "expect canary server for beta users")
+	}
+	_ = r.SetRules(nil)
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		seen[call(context.Background())] = true
+	}
+	_assert(len(seen) == 2, "expect both servers after rules are removed, got %v", seen)
+}
+
+func TestXClient_Subset(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(registry.New(registry.DefaultTimeout))
+	defer ts.Close()
+	var names []string
+	for i := 0; i < 6; i++ {
+		names = append(names, fmt.Sprintf("subset-%d", i))
+	}
+	startServers(t, ts.URL, "Who.Am", names, nil)
+
+	subset := func(id string) map[string]bool {
+		xc := NewXClient(ts.URL, "RoundRobin", nil, 0)
+		defer func() { _ = xc.Close() }()
+		if id != "" {
+			xc.SetClientID(id)
+		}
+		xc.SetSubsetSize(2)
+		seen := map[string]bool{}
+		for i := 0; i < 12; i++ {
+			reply, err := CallTyped[int, string](context.Background(), xc, "Who.Am", 0)
+			_assert(err == nil, "call error: %v", err)
+			seen[reply] = true
+		}
+		_assert(len(seen) == 2 && len(xc.clients) == 2, "expect connections to a subset of 2 servers, got %v", seen)
+		return seen
+	}
+	// 同一标识（默认为主机名）在重建客户端后得到相同的子集
+	_assert(reflect.DeepEqual(subset(""), subset("")), "expect the default subset to be stable")
+	_assert(reflect.DeepEqual(subset("instance-1"), subset("instance-1")), "expect the same subset for the same client id")
+}
+
+func TestProbeHealth(t *testing.T) {
+	t.Parallel()
+	server := startServer(t, "bar-probe")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_assert(ProbeHealth(ctx, transport.MemNetwork+"@bar-probe") == nil, "expect responsive server to be healthy")
+	_assert(ProbeHealth(ctx, transport.MemNetwork+"@bar-missing") != nil, "expect unreachable server to be unhealthy")
+	_ = server.SetServingStatus("", service.Draining)
+	err := ProbeHealth(ctx, transport.MemNetwork+"@bar-probe")
+	_assert(err != nil && strings.Contains(err.Error(), service.Draining), "expect draining server to be unhealthy, got %v", err)
+}
+
+func TestXClient_Discovery(t *testing.T) {
+	t.Parallel()
+	t.Run("file", func(t *testing.T) {
+		startServer(t, "bar-file-1")
+		startServer(t, "bar-file-2")
+		path := filepath.Join(t.TempDir(), "services.json")
+		_ = os.WriteFile(path, []byte(`{"Bar": ["mem@bar-file-1", "mem@bar-file-2"], "Bar.Timeout": []}`), 0644)
+		d, err := registry.NewFileDiscovery(path, 0)
+		_assert(err == nil, "load error: %v", err)
+		defer func() { _ = d.Close() }()
+		xc := NewXClientWithDiscovery(d, "RoundRobin", nil, 0)
+		defer func() { _ = xc.Close() }()
+		for i := 0; i < 4; i++ {
+			reply, err := CallTyped[int, int](context.Background(), xc, "Bar.Double", i)
+			_assert(err == nil && reply == 2*i, "expect %d, got %d, err %v", 2*i, reply, err)
+		}
+		err = xc.Call("Bar.Timeout", 1, nil, time.Second)
+		_assert(err != nil && strings.Contains(err.Error(), "no available server"), "expect no server for Bar.Timeout, got %v", err)
+		_ = xc.SetVersion("Bar", "^1")
+		err = xc.Call("Bar.Double", 1, nil, time.Second)
+		_assert(err != nil, "expect servers without versions filtered out")
+	})
+	t.Run("gossip", func(t *testing.T) {
+		cfg := registry.GossipConfig{ProbeInterval: 50 * time.Millisecond}
+		var seed string
+		for i := 0; i < 3; i++ {
+			name := fmt.Sprintf("bar-gossip-%d", i)
+			server := startServer(t, name)
+			c := cfg
+			if seed != "" {
+				c.Seeds = []string{seed}
+			}
+			g, err := registry.NewGossip(c)
+			_assert(err == nil, "start gossip error: %v", err)
+			defer func() { _ = g.Close() }()
+			seed = g.Addr()
+			_ = server.SetVersion("Bar", "1.0.0")
+			server.JoinGossip(g)
+		}
+		c := cfg
+		c.Seeds = []string{seed}
+		d, err := registry.NewGossipDiscovery(c)
+		_assert(err == nil, "start observer error: %v", err)
+		defer func() { _ = d.Close() }()
+		xc := NewXClientWithDiscovery(d, "RoundRobin", nil, 0)
+		defer func() { _ = xc.Close() }()
+		_ = xc.SetVersion("Bar", "^1")
+		deadline := time.Now().Add(5 * time.Second)
+		for items, _ := xc.DiscoverAll("Bar.Double"); len(items) != 3; items, _ = xc.DiscoverAll("Bar.Double") {
+			_assert(time.Now().Before(deadline), "expect 3 servers, got %v", items)
+			time.Sleep(20 * time.Millisecond)
+		}
+		reply, err := CallTyped[int, int](context.Background(), xc, "Bar.Double", 21)
+		_assert(err == nil && reply == 42, "expect 42, got %d, err %v", reply, err)
+	})
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"zrpc/registry"
+	"zrpc/service"
+)
+
+// ProbeHealth 连接rpcAddr（形如"tcp@127.0.0.1:8080"）并调用registry.HealthCheckMethod，
+// 可作为注册中心的主动健康检查：registry.HealthCheck(client.ProbeHealth, period, timeout)。
+// 状态不是SERVING（包括DRAINING）时视为不健康，未提供健康检查服务的服务器只要能在超时前响应即视为健康
+func ProbeHealth(ctx context.Context, rpcAddr string) error {
+	addr := strings.Split(rpcAddr, "@")
+	if len(addr) != 2 {
+		return errors.New("rpc client: wrong rpcAddr")
+	}
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	c, err := Dial(addr[0], addr[1], timeout)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.Close() }()
+	var status string
+	err = c.Call(registry.HealthCheckMethod, "", &status, ctx)
+	if errors.Is(err, service.ErrNotFound) {
+		return nil
+	}
+	if err == nil && status != service.Serving {
+		return errors.New("rpc client: server is " + status)
+	}
+	return err
+}
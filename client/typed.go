@@ -0,0 +1,10 @@
+package client
+
+import "context"
+
+// CallTyped 是XClient.CallContext的类型化版本，参数与应答类型在编译期检查
+func CallTyped[Req, Resp any](ctx context.Context, xc *XClient, serviceMethod string, req Req) (Resp, error) {
+	var reply Resp
+	err := xc.CallContext(ctx, serviceMethod, req, &reply)
+	return reply, err
+}
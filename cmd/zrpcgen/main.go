@@ -0,0 +1,301 @@
+// zrpcgen 为符合服务注册规则的类型生成类型化的XClient调用桩。
+//
+// 用法（通常写在服务所在包的go:generate注释中）：
+//
+//	//go:generate go run zrpc/cmd/zrpcgen -type Foo
+//
+// 将在同一目录下生成 zrpc_client.go，其中 FooClient.Sum(ctx, Args) (int, error)
+// 包装了 XClient.CallContext(ctx, "Foo.Sum", args, &reply)。
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "以逗号分隔的服务类型名，为空时处理包内所有符合规则的类型")
+	output    = flag.String("output", "zrpc_client.go", "输出文件名，相对于包目录")
+	zrpcPath  = flag.String("zrpc", "zrpc", "zrpc模块的导入路径")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("zrpcgen: ")
+	flag.Parse()
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	var names []string
+	if *typeNames != "" {
+		names = strings.Split(*typeNames, ",")
+	}
+	src, err := generate(dir, names, *zrpcPath, *output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, *output), src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// 一个可被远程调用的方法，即service.registerMethods接受的形式：func (T) Method(Arg, *Reply) error
+type stubMethod struct {
+	Name      string
+	ArgType   string
+	ReplyType string            // 去掉指针后的应答类型
+	Imports   map[string]string // 参数与应答类型引用的包：包名 -> 导入路径
+}
+
+type stubService struct {
+	Name    string
+	Methods []stubMethod
+}
+
+func generate(dir string, names []string, zrpcPath, output string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		name := fi.Name()
+		return !strings.HasSuffix(name, "_test.go") && name != output
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expect exactly one package in %s, found %d", dir, len(pkgs))
+	}
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+	services := collect(pkg, names)
+	if len(services) == 0 {
+		return nil, fmt.Errorf("no service type found in %s", dir)
+	}
+	return render(pkg.Name, zrpcPath, services)
+}
+
+func collect(pkg *ast.Package, names []string) []*stubService {
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[strings.TrimSpace(name)] = true
+	}
+	byName := map[string]*stubService{}
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 {
+				continue
+			}
+			recv := receiverName(fn.Recv.List[0].Type)
+			if !ast.IsExported(recv) || (len(wanted) > 0 && !wanted[recv]) {
+				continue
+			}
+			m, ok := methodOf(fn)
+			if !ok {
+				continue
+			}
+			if m.Imports, ok = importsOf(file, fn); !ok {
+				log.Printf("skip %s.%s: can't resolve the packages of its types", recv, m.Name)
+				continue
+			}
+			svc := byName[recv]
+			if svc == nil {
+				svc = &stubService{Name: recv}
+				byName[recv] = svc
+			}
+			svc.Methods = append(svc.Methods, m)
+		}
+	}
+	services := make([]*stubService, 0, len(byName))
+	for _, svc := range byName {
+		sort.Slice(svc.Methods, func(i, j int) bool { return svc.Methods[i].Name < svc.Methods[j].Name })
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
+
+func receiverName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// 与service.registerMethods的判断保持一致
+func methodOf(fn *ast.FuncDecl) (stubMethod, bool) {
+	if !fn.Name.IsExported() {
+		return stubMethod{}, false
+	}
+	params := flatten(fn.Type.Params)
+	results := flatten(fn.Type.Results)
+	if len(params) != 2 || len(results) != 1 {
+		return stubMethod{}, false
+	}
+	if ident, ok := results[0].(*ast.Ident); !ok || ident.Name != "error" {
+		return stubMethod{}, false
+	}
+	reply, ok := params[1].(*ast.StarExpr)
+	if !ok {
+		return stubMethod{}, false
+	}
+	if !isExportedOrBuiltin(params[0]) && !isExportedOrBuiltin(reply) {
+		return stubMethod{}, false
+	}
+	return stubMethod{
+		Name:      fn.Name.Name,
+		ArgType:   types.ExprString(params[0]),
+		ReplyType: types.ExprString(reply.X),
+	}, true
+}
+
+// 收集方法参数与应答类型中 pkg.Type 形式引用的包，按所在文件的导入声明解析
+func importsOf(file *ast.File, fn *ast.FuncDecl) (map[string]string, bool) {
+	byName := map[string]string{}
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := packageName(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		byName[name] = importPath
+	}
+	imports := map[string]string{}
+	resolved := true
+	for _, expr := range flatten(fn.Type.Params) {
+		ast.Inspect(expr, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if ident, ok := sel.X.(*ast.Ident); ok {
+				if importPath, ok := byName[ident.Name]; ok {
+					imports[ident.Name] = importPath
+				} else {
+					resolved = false
+				}
+			}
+			return false
+		})
+	}
+	return imports, resolved
+}
+
+// 未指定包名时按导入路径推断，如 "gopkg.in/yaml.v3" -> yaml，"example.com/foo/v2" -> foo
+func packageName(importPath string) string {
+	name := path.Base(importPath)
+	if len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = path.Base(path.Dir(importPath))
+	}
+	if dot := strings.Index(name, "."); dot > 0 {
+		name = name[:dot]
+	}
+	return strings.TrimPrefix(name, "go-")
+}
+
+// 将 (a, b int) 展开为每个参数一项
+func flatten(fields *ast.FieldList) []ast.Expr {
+	if fields == nil {
+		return nil
+	}
+	var exprs []ast.Expr
+	for _, field := range fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			exprs = append(exprs, field.Type)
+		}
+	}
+	return exprs
+}
+
+func isExportedOrBuiltin(expr ast.Expr) bool {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.Ident:
+			return ast.IsExported(e.Name) || types.Universe.Lookup(e.Name) != nil
+		default: // 其他包的类型、复合类型
+			return true
+		}
+	}
+}
+
+func render(pkgName, zrpcPath string, services []*stubService) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by zrpcgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkgName)
+	imports := map[string]string{}
+	for _, svc := range services {
+		for _, m := range svc.Methods {
+			for name, importPath := range m.Imports {
+				if other, ok := imports[name]; ok && other != importPath {
+					return nil, fmt.Errorf("package name %s refers to both %s and %s", name, other, importPath)
+				}
+				imports[name] = importPath
+			}
+		}
+	}
+	// 与生成代码使用的包重名时，为zrpc的client包取别名
+	clientName := "client"
+	if _, ok := imports[clientName]; ok {
+		clientName = "zrpcclient"
+	}
+	imports[clientName] = zrpcPath + "/client"
+	if importPath, ok := imports["context"]; ok && importPath != "context" {
+		return nil, fmt.Errorf("package name context refers to %s", importPath)
+	}
+	imports["context"] = "context"
+	names := make([]string, 0, len(imports))
+	for name := range imports {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return imports[names[i]] < imports[names[j]] })
+	fmt.Fprintf(&buf, "import (\n")
+	for _, name := range names {
+		if packageName(imports[name]) == name {
+			fmt.Fprintf(&buf, "\t%q\n", imports[name])
+		} else {
+			fmt.Fprintf(&buf, "\t%s %q\n", name, imports[name])
+		}
+	}
+	fmt.Fprintf(&buf, ")\n")
+	for _, svc := range services {
+		fmt.Fprintf(&buf, "\n// %sClient 是服务%s的类型化客户端\n", svc.Name, svc.Name)
+		fmt.Fprintf(&buf, "type %sClient struct {\n\txc *%s.XClient\n}\n\n", svc.Name, clientName)
+		fmt.Fprintf(&buf, "func New%sClient(xc *%s.XClient) *%sClient {\n\treturn &%sClient{xc: xc}\n}\n",
+			svc.Name, clientName, svc.Name, svc.Name)
+		for _, m := range svc.Methods {
+			fmt.Fprintf(&buf, "\nfunc (c *%sClient) %s(ctx context.Context, args %s) (%s, error) {\n",
+				svc.Name, m.Name, m.ArgType, m.ReplyType)
+			fmt.Fprintf(&buf, "\tvar reply %s\n", m.ReplyType)
+			fmt.Fprintf(&buf, "\terr := c.xc.CallContext(ctx, %q, args, &reply)\n", svc.Name+"."+m.Name)
+			fmt.Fprintf(&buf, "\treturn reply, err\n}\n")
+		}
+	}
+	return format.Source(buf.Bytes())
+}
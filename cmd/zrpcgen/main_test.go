@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const serviceSrc = `package demo
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error { return nil }
+
+func (f *Foo) Names(prefix string, reply *[]string) error { return nil }
+
+func (f Foo) sum(args Args, reply *int) error { return nil }
+
+func (f Foo) Bad(args Args, reply int) error { return nil }
+
+func (f Foo) TwoResults(args Args, reply *int) (int, error) { return 0, nil }
+
+type bar int
+
+func (b bar) Sum(args Args, reply *int) error { return nil }
+`
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed:"+msg, v...))
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "foo.go"), []byte(serviceSrc), 0644)
+
+	src, err := generate(dir, nil, "zrpc", "zrpc_client.go")
+	_assert(err == nil, "generate error: %v", err)
+	out := string(src)
+	_assert(strings.Contains(out, "package demo"), "wrong package:\n%s", out)
+	_assert(strings.Contains(out, "func (c *FooClient) Sum(ctx context.Context, args Args) (int, error)"), "missing Sum:\n%s", out)
+	_assert(strings.Contains(out, `c.xc.CallContext(ctx, "Foo.Sum", args, &reply)`), "wrong method string:\n%s", out)
+	_assert(strings.Contains(out, "func (c *FooClient) Names(ctx context.Context, args string) ([]string, error)"), "missing Names:\n%s", out)
+	for _, unexpected := range []string{") sum(", ") Bad(", ") TwoResults(", "barClient"} {
+		_assert(!strings.Contains(out, unexpected), "unexpected %s in:\n%s", unexpected, out)
+	}
+
+	_, err = generate(dir, []string{"Baz"}, "zrpc", "zrpc_client.go")
+	_assert(err != nil, "expect error for missing type")
+}
+
+const qualifiedSrc = `package demo
+
+import (
+	"strings"
+	"time"
+	pb "net/url"
+)
+
+type Timer int
+
+func (t Timer) Sleep(d time.Duration, reply *pb.Values) error {
+	_ = strings.ToUpper("unused in signatures")
+	return nil
+}
+
+func (t Timer) Since(at map[string]time.Time, reply *[]pb.URL) error { return nil }
+`
+
+func TestGenerate_QualifiedTypes(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	_assert(err == nil, "abs error: %v", err)
+	// 在临时模块中编译生成的代码，通过replace引用当前源码树
+	dir := t.TempDir()
+	gomod := "module demo\n\ngo 1.18\n\nrequire zrpc v0.0.0\n\nreplace zrpc => " + root + "\n"
+	_ = os.WriteFile(filepath.Join(dir, "go.mod"), []byte(gomod), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "timer.go"), []byte(qualifiedSrc), 0644)
+
+	src, err := generate(dir, nil, "zrpc", "zrpc_client.go")
+	_assert(err == nil, "generate error: %v", err)
+	out := string(src)
+	_assert(strings.Contains(out, `"time"`) && strings.Contains(out, `pb "net/url"`), "missing imports:\n%s", out)
+	_assert(!strings.Contains(out, `"strings"`), "unexpected unused import:\n%s", out)
+	_assert(strings.Contains(out, "Sleep(ctx context.Context, args time.Duration) (pb.Values, error)"), "missing Sleep:\n%s", out)
+	_ = os.WriteFile(filepath.Join(dir, "zrpc_client.go"), src, 0644)
+
+	cmd := exec.Command(gobin, "build", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod")
+	msg, err := cmd.CombinedOutput()
+	_assert(err == nil, "generated code doesn't compile: %v\n%s\n%s", err, msg, out)
+}
+
+func TestPackageName(t *testing.T) {
+	for importPath, name := range map[string]string{
+		"time":                  "time",
+		"net/url":               "url",
+		"gopkg.in/yaml.v3":      "yaml",
+		"example.com/foo/v2":    "foo",
+		"github.com/x/go-redis": "redis",
+	} {
+		_assert(packageName(importPath) == name, "expect %s for %s, got %s", name, importPath, packageName(importPath))
+	}
+}
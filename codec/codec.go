@@ -29,4 +29,5 @@ var NewCodecFuncMap map[Type]NewCodecFunc
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec // 该函数将会返回一个gob coder
+	NewCodecFuncMap[JsonType] = NewJsonCodec
 }
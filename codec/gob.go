@@ -40,13 +40,13 @@ func BytesToInt32(buf []byte) int32 {
 
 func (c *GobCodec) ReadHeader(h *Header) error {
 	lengthBytes := make([]byte, 4)
-	_, err := c.conn.Read(lengthBytes)
+	_, err := io.ReadFull(c.conn, lengthBytes)
 	if err != nil {
 		return err
 	}
 	length := BytesToInt32(lengthBytes)
 	content := make([]byte, length)
-	_, err = c.conn.Read(content)
+	_, err = io.ReadFull(c.conn, content)
 	if err != nil {
 		return err
 	}
@@ -56,13 +56,13 @@ func (c *GobCodec) ReadHeader(h *Header) error {
 
 func (c *GobCodec) ReadBody(body interface{}) error {
 	lengthBytes := make([]byte, 4)
-	_, err := c.conn.Read(lengthBytes)
+	_, err := io.ReadFull(c.conn, lengthBytes)
 	if err != nil {
 		return err
 	}
 	length := BytesToInt32(lengthBytes)
 	content := make([]byte, length)
-	_, err = c.conn.Read(content)
+	_, err = io.ReadFull(c.conn, content)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,82 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log"
+)
+
+// JsonCodec 与GobCodec使用相同的长度前缀分帧，帧内容为json
+type JsonCodec struct {
+	conn   io.ReadWriteCloser
+	encBuf *bytes.Buffer
+}
+
+var _ Codec = (*JsonCodec)(nil)
+
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	return &JsonCodec{
+		conn:   conn,
+		encBuf: &bytes.Buffer{},
+	}
+}
+
+func (c *JsonCodec) readFrame(v interface{}) error {
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(c.conn, lengthBytes); err != nil {
+		return err
+	}
+	content := make([]byte, BytesToInt32(lengthBytes))
+	if _, err := io.ReadFull(c.conn, content); err != nil {
+		return err
+	}
+	if v == nil { // 丢弃该帧
+		return nil
+	}
+	return json.Unmarshal(content, v)
+}
+
+func (c *JsonCodec) ReadHeader(h *Header) error {
+	if h == nil {
+		return c.readFrame(nil)
+	}
+	return c.readFrame(h)
+}
+
+func (c *JsonCodec) ReadBody(body interface{}) error {
+	return c.readFrame(body)
+}
+
+func (c *JsonCodec) writeFrame(v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	length := int32(len(content))
+	if err = binary.Write(c.encBuf, binary.BigEndian, &length); err != nil {
+		return err
+	}
+	c.encBuf.Write(content)
+	return nil
+}
+
+func (c *JsonCodec) Write(h *Header, body interface{}) error {
+	defer c.encBuf.Reset()
+	if err := c.writeFrame(h); err != nil {
+		log.Println("rpc codec: json error encoding header:", err)
+		return err
+	}
+	if err := c.writeFrame(body); err != nil {
+		log.Println("rpc codec: json error encoding body:", err)
+		return err
+	}
+	// 头部与body一次写出
+	_, err := c.conn.Write(c.encBuf.Bytes())
+	return err
+}
+
+func (c *JsonCodec) Close() error {
+	return c.conn.Close()
+}
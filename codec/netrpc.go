@@ -0,0 +1,63 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"log"
+	"reflect"
+)
+
+// NetRPCCodec 与标准库net/rpc的gob协议兼容：无握手、无长度前缀，直接在连接上连续编码gob值。
+// gob按字段名匹配结构体，Header可与rpc.Request/rpc.Response互相解码
+type NetRPCCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	dec  *gob.Decoder
+	enc  *gob.Encoder
+}
+
+var _ Codec = (*NetRPCCodec)(nil)
+
+func NewNetRPCCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	return &NetRPCCodec{
+		conn: conn,
+		buf:  buf,
+		dec:  gob.NewDecoder(conn),
+		enc:  gob.NewEncoder(buf),
+	}
+}
+
+func (c *NetRPCCodec) ReadHeader(h *Header) error {
+	if h == nil { // 丢弃该值
+		return c.dec.DecodeValue(reflect.Value{})
+	}
+	return c.dec.Decode(h)
+}
+
+func (c *NetRPCCodec) ReadBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *NetRPCCodec) Write(h *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if err = c.enc.Encode(h); err != nil {
+		log.Println("rpc codec: net/rpc error encoding header:", err)
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		log.Println("rpc codec: net/rpc error encoding body:", err)
+		return
+	}
+	return
+}
+
+func (c *NetRPCCodec) Close() error {
+	return c.conn.Close()
+}
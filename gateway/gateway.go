@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"zrpc/client"
+	"zrpc/codec"
+	"zrpc/service"
+)
+
+// Handler 将 POST /{Service}/{Method} 的json请求转换为一次rpc调用，应答以json返回
+type Handler struct {
+	invoke func(serviceMethod string, body []byte) (interface{}, error)
+	xc     *client.XClient
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+type badRequestError struct {
+	err error
+}
+
+func (e *badRequestError) Error() string { return "gateway: invalid request body: " + e.err.Error() }
+
+// NewLocal 直接调用进程内的Server，按方法的ArgType解码请求体
+func NewLocal(server *service.Server) *Handler {
+	return &Handler{
+		invoke: func(serviceMethod string, body []byte) (interface{}, error) {
+			return server.Invoke(serviceMethod, func(argv interface{}) error {
+				if len(body) == 0 {
+					return nil
+				}
+				if err := json.Unmarshal(body, argv); err != nil {
+					return &badRequestError{err}
+				}
+				return nil
+			})
+		},
+	}
+}
+
+// NewRemote 通过XClient调用远端服务，连接使用json编解码，请求体原样转发给服务端解码
+func NewRemote(registerAddr, mode string, timeout time.Duration) *Handler {
+	xc := client.NewXClient(registerAddr, mode, &service.Option{CodecType: codec.JsonType}, 0)
+	return &Handler{
+		xc: xc,
+		invoke: func(serviceMethod string, body []byte) (interface{}, error) {
+			if len(body) == 0 {
+				body = []byte("null")
+			} else if !json.Valid(body) {
+				return nil, &badRequestError{errors.New("malformed json")}
+			}
+			var reply json.RawMessage
+			if err := xc.Call(serviceMethod, json.RawMessage(body), &reply, timeout); err != nil {
+				return nil, err
+			}
+			return reply, nil
+		},
+	}
+}
+
+func (h *Handler) Close() error {
+	if h.xc != nil {
+		return h.xc.Close()
+	}
+	return nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("gateway: must POST"))
+		return
+	}
+	path := strings.Trim(req.URL.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	if slash <= 0 || slash == len(path)-1 {
+		writeError(w, http.StatusNotFound, errors.New("gateway: path must be /{Service}/{Method}"))
+		return
+	}
+	serviceMethod := path[:slash] + "." + path[slash+1:]
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	reply, err := h.invoke(serviceMethod, body)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(reply); err != nil {
+		log.Println("rpc gateway: encode reply error:", err)
+	}
+}
+
+func statusOf(err error) int {
+	var bad *badRequestError
+	switch {
+	case errors.As(err, &bad):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
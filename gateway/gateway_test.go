@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"zrpc/registry"
+	"zrpc/service"
+	"zrpc/transport"
+)
+
+type Foo int
+
+type Args struct {
+	Num1, Num2 int
+}
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed:"+msg, v...))
+	}
+}
+
+func post(url, body string) (int, string) {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	_assert(err == nil, "post error: %v", err)
+	defer func() { _ = resp.Body.Close() }()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(b))
+}
+
+func TestHandler_Local(t *testing.T) {
+	server := service.NewServer("", "")
+	_ = server.Register(new(Foo))
+	ts := httptest.NewServer(NewLocal(server))
+	defer ts.Close()
+
+	code, body := post(ts.URL+"/Foo/Sum", `{"Num1":1,"Num2":2}`)
+	_assert(code == http.StatusOK && body == "3", "expect 3, got %d %s", code, body)
+	code, _ = post(ts.URL+"/Foo/Mul", `{}`)
+	_assert(code == http.StatusNotFound, "expect 404, got %d", code)
+	code, _ = post(ts.URL+"/Foo/Sum", `{"Num1":`)
+	_assert(code == http.StatusBadRequest, "expect 400, got %d", code)
+}
+
+func TestHandler_Remote(t *testing.T) {
+	reg := httptest.NewServer(registry.New(registry.DefaultTimeout))
+	defer reg.Close()
+	l, _ := transport.ListenMem("gateway-foo")
+	server := service.NewServer(reg.URL, "mem@gateway-foo")
+	_ = server.Register(new(Foo))
+	server.Heartbeat(0) // 先完成注册，避免与Listen中的心跳竞争
+	go server.Listen(l, 0)
+	defer func() { _ = l.Close() }()
+
+	h := NewRemote(reg.URL, "RoundRobin", 0)
+	defer func() { _ = h.Close() }()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	code, body := post(ts.URL+"/Foo/Sum", `{"Num1":20,"Num2":22}`)
+	_assert(code == http.StatusOK && body == "42", "expect 42, got %d %s", code, body)
+	code, _ = post(ts.URL+"/Foo/Sum", `not json`)
+	_assert(code == http.StatusBadRequest, "expect 400, got %d", code)
+}
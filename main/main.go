@@ -88,12 +88,12 @@ func startServer(registryAddr string, wg *sync.WaitGroup) {
 	var foo Foo
 	l, err := net.Listen("tcp", ":0") //开启监听器
 	if err != nil {
-		log.Fatalf("network error:", err)
+		log.Fatal("network error:", err)
 	}
 	server := service.NewServer(registryAddr, "tcp@"+l.Addr().String())
 	err = server.Register(&foo)
 	if err != nil {
-		log.Fatalf("register error:", err)
+		log.Fatal("register error:", err)
 	}
 	//service.Heartbeat(registryAddr, "tcp@"+l.Addr().String(), 0)
 	wg.Done()
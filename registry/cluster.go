@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"zrpc/balancer"
+)
+
+const (
+	replicatedHeader   = "X-Zrpc-Replicated" // 由其他节点转发的请求，不再继续转发
+	syncHeader         = "X-Zrpc-Sync"       // 请求全量状态
+	rulesVersionHeader = "X-Zrpc-Rules-Version"
+)
+
+// Endpoints 多个注册中心地址，请求失败（网络错误或5xx）时依次切换到下一个，并记住最近一次成功的地址
+type Endpoints struct {
+	addrs  []string
+	last   uint32
+	client *http.Client
+}
+
+// NewEndpoints registerAddr为以逗号分隔的一个或多个注册中心地址
+func NewEndpoints(registerAddr string) *Endpoints {
+	e := &Endpoints{client: &http.Client{Timeout: 10 * time.Second}}
+	for _, addr := range strings.Split(registerAddr, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			e.addrs = append(e.addrs, addr)
+		}
+	}
+	return e
+}
+
+// Do 以newReq为某个地址构造请求并发送，失败时尝试下一个地址
+func (e *Endpoints) Do(newReq func(addr string) (*http.Request, error)) (*http.Response, error) {
+	if len(e.addrs) == 0 {
+		return nil, errors.New("rpc registry: no registry address")
+	}
+	start := int(atomic.LoadUint32(&e.last))
+	var lastErr error
+	for i := 0; i < len(e.addrs); i++ {
+		idx := (start + i) % len(e.addrs)
+		req, err := newReq(e.addrs[idx])
+		if err != nil {
+			return nil, err
+		}
+		resp, err := e.client.Do(req)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			atomic.StoreUint32(&e.last, uint32(idx))
+			return resp, nil
+		}
+		if err == nil {
+			_ = resp.Body.Close()
+			err = errors.New(resp.Status)
+		}
+		lastErr = err
+		log.Println("rpc registry: registry", e.addrs[idx], "failed:", err)
+	}
+	return nil, errors.New("rpc registry: all registries failed: " + lastErr.Error())
+}
+
+// DefaultPeerSyncPeriod 集群节点之间定期全量同步的默认间隔
+const DefaultPeerSyncPeriod = 30 * time.Second
+
+// SetPeers 开启集群模式，peers为其他节点的完整地址（如 "http://10.0.0.2:9999/registry"）。
+// 本节点收到的心跳与路由规则变更会异步转发给所有peers，各节点均可读写；
+// 加入集群时先从任一可用的peer同步全量状态，之后定期从所有peers同步，补齐转发失败的变更。
+// 路由规则按版本号（更新时间）以最后写入为准。调用Close后停止同步
+func (r *ZRegistry) SetPeers(peers ...string) {
+	r.mu.Lock()
+	r.peers = append([]string(nil), peers...)
+	if r.syncStop == nil {
+		r.syncStop = make(chan struct{})
+		go r.syncLoop(r.syncPeriod, r.syncStop)
+	}
+	r.mu.Unlock()
+	for _, peer := range peers {
+		err := r.syncFrom(peer)
+		if err == nil {
+			return
+		}
+		log.Println("rpc registry: sync from", peer, "failed:", err)
+	}
+}
+
+// SetPeerSyncPeriod 设置定期全量同步的间隔，需在SetPeers之前调用
+func (r *ZRegistry) SetPeerSyncPeriod(period time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.syncPeriod = period
+}
+
+// 反熵：定期从每个peer拉取全量状态，转发失败的心跳与规则变更最迟一个周期后补齐
+func (r *ZRegistry) syncLoop(period time.Duration, stop chan struct{}) {
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+		r.mu.Lock()
+		peers := r.peers
+		r.mu.Unlock()
+		for _, peer := range peers {
+			if err := r.syncFrom(peer); err != nil {
+				log.Println("rpc registry: sync from", peer, "failed:", err)
+			}
+		}
+	}
+}
+
+func (r *ZRegistry) syncFrom(peer string) error {
+	req, err := http.NewRequest(http.MethodGet, peer, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(syncHeader, "1")
+	resp, err := r.peerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	var snap snapshot
+	if err = json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range snap.Servers {
+		if _, ok := r.servers[s.Addr]; !ok {
+			r.logEntry(&walEntry{Op: opPut, Addr: s.Addr, Methods: s.Methods, Versions: s.Versions, Meta: s.Meta})
+			r.put(s.Addr, s.Methods, s.Versions, s.Meta)
+		}
+	}
+	r.setRules(snap.Rules, snap.RulesVersion)
+	return nil
+}
+
+// 版本号更新时才替换路由规则，调用方需持有r.mu
+func (r *ZRegistry) setRules(rules []balancer.Rule, version int64) bool {
+	if version <= r.rulesVersion {
+		return false
+	}
+	r.rules = append([]balancer.Rule(nil), rules...)
+	r.rulesVersion = version
+	r.logEntry(&walEntry{Op: opRules, Rules: r.rules, RulesVersion: version})
+	return true
+}
+
+// 将请求异步转发给所有peers
+func (r *ZRegistry) replicate(method string, header http.Header, body []byte) {
+	r.mu.Lock()
+	peers := r.peers
+	r.mu.Unlock()
+	for _, peer := range peers {
+		go func(peer string) {
+			req, err := http.NewRequest(method, peer, bytes.NewReader(body))
+			if err != nil {
+				return
+			}
+			for k, v := range header {
+				if strings.HasPrefix(k, "X-Zrpc-") {
+					req.Header[k] = v
+				}
+			}
+			req.Header.Set(replicatedHeader, "1")
+			resp, err := r.peerClient.Do(req)
+			if err != nil {
+				log.Println("rpc registry: replicate to", peer, "failed:", err)
+				return
+			}
+			_ = resp.Body.Close()
+		}(peer)
+	}
+}
+
+func parseRulesVersion(header string) int64 {
+	v, _ := strconv.ParseInt(header, 10, 64)
+	return v
+}
@@ -22,6 +22,13 @@ type Discovery interface {
 	GetAll() ([]string, error)
 }
 
+// ServiceDiscovery 能按方法返回服务器及其元数据的Discovery，XClient优先使用该接口，
+// 否则以GetAll的结果作为所有方法的候选服务器
+type ServiceDiscovery interface {
+	Discovery
+	Servers(serviceMethod string) ([]ServerItem, error)
+}
+
 type MultiServersDiscovery struct {
 	r       *rand.Rand
 	mu      sync.RWMutex
@@ -0,0 +1,190 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"zrpc/balancer"
+)
+
+// Resolver 查询SRV记录，*net.Resolver实现了该接口，测试或自定义DNS服务器时可替换
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DefaultDNSTTL DNS查询结果的默认缓存时间
+const DefaultDNSTTL = 30 * time.Second
+
+// DNSDiscovery 通过DNS SRV记录发现服务器：服务Foo.Bar的记录为 _foo-bar._tcp.<domain>，
+// 所有服务器的记录为 _zrpc._tcp.<domain>。只使用优先级最高（Priority最小）的记录，
+// 记录的Weight作为服务器的权重元数据
+type DNSDiscovery struct {
+	*MultiServersDiscovery
+	domain   string
+	network  string // 拼接rpc地址使用的网络，默认tcp
+	resolver Resolver
+	ttl      time.Duration
+	mu       sync.Mutex
+	cache    map[string]dnsEntry // SRV记录名 -> 查询结果
+	inflight map[string]*dnsCall // SRV记录名 -> 正在进行的查询，同名的并发查询只发出一次
+}
+
+type dnsEntry struct {
+	items   []ServerItem
+	expires time.Time
+}
+
+type dnsCall struct {
+	wg    sync.WaitGroup
+	items []ServerItem
+	err   error
+}
+
+var _ ServiceDiscovery = (*DNSDiscovery)(nil)
+
+// NewDNSDiscovery resolver为nil时使用net.DefaultResolver，ttl为0时使用DefaultDNSTTL
+func NewDNSDiscovery(domain string, resolver Resolver, ttl time.Duration) *DNSDiscovery {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if ttl == 0 {
+		ttl = DefaultDNSTTL
+	}
+	return &DNSDiscovery{
+		MultiServersDiscovery: NewMultiServersDiscovery(nil),
+		domain:                strings.TrimSuffix(domain, "."),
+		network:               "tcp",
+		resolver:              resolver,
+		ttl:                   ttl,
+		cache:                 map[string]dnsEntry{},
+		inflight:              map[string]*dnsCall{},
+	}
+}
+
+// SetNetwork 指定服务器的网络类型，如 "http"、"ws"
+func (d *DNSDiscovery) SetNetwork(network string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.network = network
+}
+
+// Refresh 更新所有服务器的列表，缓存过期后才重新查询
+func (d *DNSDiscovery) Refresh() error {
+	items, err := d.Servers("")
+	if err != nil {
+		return err
+	}
+	servers := make([]string, 0, len(items))
+	for _, item := range items {
+		servers = append(servers, item.Addr)
+	}
+	return d.MultiServersDiscovery.Update(servers)
+}
+
+func (d *DNSDiscovery) Update(servers []string) error {
+	return errors.New("rpc registry: dns discovery can't be updated manually")
+}
+
+func (d *DNSDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.Get(mode)
+}
+
+func (d *DNSDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
+
+// Servers 查询提供serviceMethod的服务器，结果缓存ttl时间，serviceMethod为空时返回所有服务器。
+// 返回的是缓存的副本，调用方可以修改
+func (d *DNSDiscovery) Servers(serviceMethod string) ([]ServerItem, error) {
+	name := "zrpc"
+	if serviceMethod != "" {
+		name = srvName(serviceOf(serviceMethod))
+	}
+	d.mu.Lock()
+	if entry, ok := d.cache[name]; ok && time.Now().Before(entry.expires) {
+		d.mu.Unlock()
+		return copyItems(entry.items), nil
+	}
+	if call, ok := d.inflight[name]; ok {
+		d.mu.Unlock()
+		call.wg.Wait()
+		return copyItems(call.items), call.err
+	}
+	call := &dnsCall{}
+	call.wg.Add(1)
+	d.inflight[name] = call
+	network := d.network
+	d.mu.Unlock()
+
+	_, records, err := d.resolver.LookupSRV(context.Background(), name, "tcp", d.domain)
+	if err != nil {
+		call.err = errors.New("rpc registry: lookup srv " + name + ": " + err.Error())
+	} else {
+		call.items = srvItems(network, records)
+	}
+	d.mu.Lock()
+	if call.err == nil {
+		d.cache[name] = dnsEntry{items: call.items, expires: time.Now().Add(d.ttl)}
+	}
+	delete(d.inflight, name)
+	d.mu.Unlock()
+	call.wg.Done()
+	return copyItems(call.items), call.err
+}
+
+func copyItems(items []ServerItem) []ServerItem {
+	if items == nil {
+		return nil
+	}
+	cp := make([]ServerItem, len(items))
+	for i, item := range items {
+		cp[i] = item
+		cp[i].Meta = make(map[string]string, len(item.Meta))
+		for k, v := range item.Meta {
+			cp[i].Meta[k] = v
+		}
+	}
+	return cp
+}
+
+// 服务名转换为合法的DNS标签，如 billing.v2.Invoice -> billing-v2-invoice
+func srvName(service string) string {
+	return strings.ToLower(strings.ReplaceAll(service, ".", "-"))
+}
+
+func srvItems(network string, records []*net.SRV) []ServerItem {
+	if len(records) == 0 {
+		return nil
+	}
+	priority := records[0].Priority
+	for _, srv := range records {
+		if srv.Priority < priority {
+			priority = srv.Priority
+		}
+	}
+	var items []ServerItem
+	for _, srv := range records {
+		if srv.Priority != priority {
+			continue
+		}
+		host := strings.TrimSuffix(srv.Target, ".")
+		meta := map[string]string{}
+		if srv.Weight > 0 { // 权重为0表示不指定
+			meta[balancer.MetaWeight] = strconv.Itoa(int(srv.Weight))
+		}
+		items = append(items, ServerItem{Addr: network + "@" + net.JoinHostPort(host, strconv.Itoa(int(srv.Port))), Meta: meta})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Addr < items[j].Addr })
+	return items
+}
@@ -0,0 +1,205 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWatchPeriod 检查文件是否变化的默认间隔
+const DefaultWatchPeriod = time.Second
+
+// FileDiscovery 从本地文件读取服务到地址的映射，文件变化时自动重新加载。
+// 键为服务名或 "服务名.方法名"，后者优先；.yaml/.yml 文件按YAML解析，其他按JSON解析：
+//
+//	{"Foo": ["tcp@10.0.0.1:8001", "tcp@10.0.0.2:8001"], "Foo.Slow": ["tcp@10.0.0.3:8001"]}
+//
+//	Foo:
+//	  - tcp@10.0.0.1:8001
+//	  - tcp@10.0.0.2:8001
+//	Foo.Slow: [tcp@10.0.0.3:8001]
+type FileDiscovery struct {
+	*MultiServersDiscovery
+	path     string
+	mu       sync.Mutex
+	services map[string][]string
+	modTime  time.Time
+	size     int64
+	stop     chan struct{}
+}
+
+var _ ServiceDiscovery = (*FileDiscovery)(nil)
+
+// NewFileDiscovery 加载path并每隔period检查一次修改，period为0时使用DefaultWatchPeriod
+func NewFileDiscovery(path string, period time.Duration) (*FileDiscovery, error) {
+	if period == 0 {
+		period = DefaultWatchPeriod
+	}
+	d := &FileDiscovery{
+		MultiServersDiscovery: NewMultiServersDiscovery(nil),
+		path:                  path,
+		stop:                  make(chan struct{}),
+	}
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	go d.watch(period)
+	return d, nil
+}
+
+func (d *FileDiscovery) watch(period time.Duration) {
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-t.C:
+		}
+		// 解析失败时保留上一次的结果
+		if err := d.Refresh(); err != nil {
+			log.Println("rpc registry: reload", d.path, "error:", err)
+		}
+	}
+}
+
+// Refresh 文件的修改时间或大小变化时重新加载
+func (d *FileDiscovery) Refresh() error {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	unchanged := d.services != nil && info.ModTime().Equal(d.modTime) && info.Size() == d.size
+	d.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+	var services map[string][]string
+	switch strings.ToLower(filepath.Ext(d.path)) {
+	case ".yaml", ".yml":
+		services, err = parseServicesYAML(data)
+	default:
+		err = json.Unmarshal(data, &services)
+	}
+	if err != nil {
+		return errors.New("rpc registry: parse " + d.path + ": " + err.Error())
+	}
+	if services == nil {
+		services = map[string][]string{}
+	}
+	all := map[string]bool{}
+	for _, addrs := range services {
+		for _, addr := range addrs {
+			all[addr] = true
+		}
+	}
+	servers := make([]string, 0, len(all))
+	for addr := range all {
+		servers = append(servers, addr)
+	}
+	sort.Strings(servers)
+	d.mu.Lock()
+	d.services, d.modTime, d.size = services, info.ModTime(), info.Size()
+	d.mu.Unlock()
+	return d.MultiServersDiscovery.Update(servers)
+}
+
+func (d *FileDiscovery) Update(servers []string) error {
+	return errors.New("rpc registry: file discovery can't be updated manually")
+}
+
+// Servers 返回文件中为serviceMethod配置的地址，没有方法级配置时使用服务级配置
+func (d *FileDiscovery) Servers(serviceMethod string) ([]ServerItem, error) {
+	var addrs []string
+	if serviceMethod == "" {
+		var err error
+		if addrs, err = d.GetAll(); err != nil {
+			return nil, err
+		}
+	} else {
+		d.mu.Lock()
+		var ok bool
+		if addrs, ok = d.services[serviceMethod]; !ok {
+			addrs = d.services[serviceOf(serviceMethod)]
+		}
+		d.mu.Unlock()
+	}
+	items := make([]ServerItem, 0, len(addrs))
+	for _, addr := range addrs {
+		items = append(items, ServerItem{Addr: addr})
+	}
+	return items, nil
+}
+
+// Close 停止监视文件
+func (d *FileDiscovery) Close() error {
+	select {
+	case <-d.stop:
+	default:
+		close(d.stop)
+	}
+	return nil
+}
+
+// 只支持服务到地址列表的映射：值为 "- addr" 形式的块列表或 [a, b] 形式的行内列表，# 之后为注释
+func parseServicesYAML(data []byte) (map[string][]string, error) {
+	services := map[string][]string{}
+	var current string
+	for i, line := range strings.Split(string(data), "\n") {
+		if hash := strings.Index(line, "#"); hash >= 0 {
+			line = line[:hash]
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		lineErr := func(msg string) error {
+			return errors.New("line " + strconv.Itoa(i+1) + ": " + msg)
+		}
+		if strings.HasPrefix(trimmed, "-") {
+			if current == "" {
+				return nil, lineErr("list item without a service")
+			}
+			services[current] = append(services[current], unquote(strings.TrimSpace(trimmed[1:])))
+			continue
+		}
+		colon := strings.Index(trimmed, ":")
+		if colon <= 0 || line[0] == ' ' || line[0] == '\t' {
+			return nil, lineErr("expect \"service:\"")
+		}
+		current = unquote(strings.TrimSpace(trimmed[:colon]))
+		value := strings.TrimSpace(trimmed[colon+1:])
+		services[current] = nil
+		switch {
+		case value == "":
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			for _, addr := range strings.Split(value[1:len(value)-1], ",") {
+				if addr = unquote(strings.TrimSpace(addr)); addr != "" {
+					services[current] = append(services[current], addr)
+				}
+			}
+		default:
+			services[current] = append(services[current], unquote(value))
+		}
+	}
+	return services, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
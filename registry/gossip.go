@@ -0,0 +1,626 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 成员状态，同一成员的状态按incarnation与下列顺序决定先后
+const (
+	MemberAlive = iota
+	MemberSuspect
+	MemberDead
+)
+
+// Member gossip集群中的成员，观察者（客户端）的RPCAddr为空
+type Member struct {
+	Addr        string            `json:"addr"`          // gossip地址
+	RPCAddr     string            `json:"rpc,omitempty"` // 如 "tcp@127.0.0.1:8080"
+	Methods     []string          `json:"methods,omitempty"`
+	Versions    map[string]string `json:"versions,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	Incarnation uint64            `json:"inc"`
+	State       int               `json:"state"`
+}
+
+// 判断n是否比o更新：alive只能被更大的incarnation覆盖，suspect可覆盖同一incarnation的alive，
+// dead可覆盖同一incarnation的其他状态
+func (n *Member) supersedes(o *Member) bool {
+	if n.Incarnation != o.Incarnation {
+		return n.Incarnation > o.Incarnation
+	}
+	return n.State > o.State
+}
+
+const (
+	msgPing    = "ping"
+	msgAck     = "ack"
+	msgPingReq = "ping-req"
+	msgSync    = "sync"
+	msgSyncAck = "sync-ack"
+)
+
+type gossipMsg struct {
+	Type    string   `json:"type"`
+	Seq     uint64   `json:"seq,omitempty"`
+	Target  string   `json:"target,omitempty"`  // ping-req请求代为探测的成员
+	Updates []Member `json:"updates,omitempty"` // 捎带的成员变更，sync时为全部成员
+}
+
+// GossipConfig SWIM协议参数，零值字段使用默认值
+type GossipConfig struct {
+	BindAddr       string        // UDP监听地址，默认 "127.0.0.1:0"
+	Seeds          []string      // 已在集群中的成员地址，为空时新建集群
+	ProbeInterval  time.Duration // 每轮探测一个成员，默认1s
+	ProbeTimeout   time.Duration // 直接探测的超时，默认ProbeInterval/3
+	SuspectTimeout time.Duration // 被怀疑的成员未能反驳时判定为下线，默认5*ProbeInterval
+	DeadTimeout    time.Duration // 下线的成员保留多久后删除，期间继续传播其dead状态，默认6*SuspectTimeout
+	IndirectChecks int           // 直接探测失败后请求代为探测的成员数，默认3
+	SyncEvery      int           // 每隔多少轮与随机成员交换全部状态，默认10
+}
+
+const (
+	maxPiggyback  = 8     // 每个消息最多捎带的变更数
+	maxPacketSize = 65000 // UDP报文上限
+)
+
+type broadcast struct {
+	m         Member
+	transmits int
+}
+
+type relay struct {
+	from *net.UDPAddr
+	seq  uint64
+	at   time.Time
+}
+
+// Gossip SWIM式的去中心化成员管理：每轮随机探测一个成员，直接探测失败时请其他成员代为探测，
+// 仍失败则标记为suspect并传播，超时未被反驳则判定为dead。成员变更捎带在探测消息中传播，
+// 并定期与随机成员交换全部状态。服务端通过Advertise公布自己提供的服务
+type Gossip struct {
+	cfg      GossipConfig
+	conn     *net.UDPConn
+	mu       sync.Mutex
+	self     *Member
+	members  map[string]*Member
+	suspects map[string]time.Time // 成员 -> 被怀疑的时间
+	dead     map[string]time.Time // 成员 -> 判定下线的时间，超过DeadTimeout后删除
+	queue    []*broadcast
+	seq      uint64
+	acks     map[uint64]chan struct{}
+	relays   map[uint64]relay
+	order    []string // 本轮的探测顺序
+	rounds   int
+	r        *rand.Rand
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+
+// NewGossip 启动一个gossip成员，并通过cfg.Seeds加入集群
+func NewGossip(cfg GossipConfig) (*Gossip, error) {
+	if cfg.BindAddr == "" {
+		cfg.BindAddr = "127.0.0.1:0"
+	}
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = time.Second
+	}
+	if cfg.ProbeTimeout <= 0 {
+		cfg.ProbeTimeout = cfg.ProbeInterval / 3
+	}
+	if cfg.SuspectTimeout <= 0 {
+		cfg.SuspectTimeout = 5 * cfg.ProbeInterval
+	}
+	if cfg.DeadTimeout <= 0 {
+		cfg.DeadTimeout = 6 * cfg.SuspectTimeout
+	}
+	if cfg.IndirectChecks <= 0 {
+		cfg.IndirectChecks = 3
+	}
+	if cfg.SyncEvery <= 0 {
+		cfg.SyncEvery = 10
+	}
+	addr, err := net.ResolveUDPAddr("udp", cfg.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetReadBuffer(64 * maxPacketSize) // 全量同步会连续收到多个大报文
+	// incarnation从当前时间开始，重启后的成员能够覆盖集群中旧的dead状态
+	self := &Member{Addr: conn.LocalAddr().String(), Incarnation: uint64(time.Now().UnixNano()), State: MemberAlive}
+	g := &Gossip{
+		cfg:      cfg,
+		conn:     conn,
+		self:     self,
+		members:  map[string]*Member{self.Addr: self},
+		suspects: map[string]time.Time{},
+		dead:     map[string]time.Time{},
+		acks:     map[uint64]chan struct{}{},
+		relays:   map[uint64]relay{},
+		r:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		stop:     make(chan struct{}),
+	}
+	g.done.Add(2)
+	go g.readLoop()
+	go g.probeLoop()
+	g.join()
+	return g, nil
+}
+
+// Addr 本成员的gossip地址，可作为其他成员的种子
+func (g *Gossip) Addr() string {
+	return g.self.Addr
+}
+
+// Advertise 公布本成员提供的服务，内容变化时递增incarnation并传播
+func (g *Gossip) Advertise(rpcAddr string, methods []string, versions, meta map[string]string) {
+	methods = append([]string(nil), methods...)
+	sort.Strings(methods)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.self
+	if s.RPCAddr == rpcAddr && equalSlice(s.Methods, methods) && equalMap(s.Versions, versions) && equalMap(s.Meta, meta) {
+		return
+	}
+	s.RPCAddr, s.Methods, s.Versions, s.Meta = rpcAddr, methods, versions, meta
+	s.Incarnation++
+	g.enqueue(*s)
+}
+
+func equalSlice(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Members 返回所有未下线的成员
+func (g *Gossip) Members() []Member {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	members := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		if m.State != MemberDead {
+			members = append(members, *m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Addr < members[j].Addr })
+	return members
+}
+
+// Leave 通知其他成员本成员主动下线，然后关闭
+func (g *Gossip) Leave() error {
+	g.mu.Lock()
+	g.self.Incarnation++
+	g.self.State = MemberDead
+	leave := gossipMsg{Type: msgSync, Updates: []Member{*g.self}}
+	var peers []string
+	for addr, m := range g.members {
+		if m != g.self && m.State != MemberDead {
+			peers = append(peers, addr)
+		}
+	}
+	g.mu.Unlock()
+	for _, addr := range peers {
+		g.sendTo(addr, &leave)
+	}
+	return g.Close()
+}
+
+// Close 停止参与gossip，其他成员将通过探测发现本成员下线
+func (g *Gossip) Close() error {
+	select {
+	case <-g.stop:
+		return nil
+	default:
+	}
+	close(g.stop)
+	err := g.conn.Close()
+	g.done.Wait()
+	return err
+}
+
+func (g *Gossip) join() {
+	msg := gossipMsg{Type: msgSync, Updates: g.snapshot()}
+	for _, seed := range g.cfg.Seeds {
+		g.sendTo(seed, &msg)
+	}
+}
+
+func (g *Gossip) snapshot() []Member {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	members := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		members = append(members, *m)
+	}
+	return members
+}
+
+// 调用方需持有g.mu
+func (g *Gossip) enqueue(m Member) {
+	for i, b := range g.queue { // 同一成员只保留最新的变更
+		if b.m.Addr == m.Addr {
+			g.queue = append(g.queue[:i], g.queue[i+1:]...)
+			break
+		}
+	}
+	g.queue = append(g.queue, &broadcast{m: m})
+}
+
+// 每条变更传播约 3*log2(n) 次，调用方需持有g.mu
+func (g *Gossip) piggyback() []Member {
+	limit := 3 * int(math.Ceil(math.Log2(float64(len(g.members)+1))))
+	var updates []Member
+	kept := g.queue[:0]
+	for _, b := range g.queue {
+		if len(updates) < maxPiggyback {
+			updates = append(updates, b.m)
+			b.transmits++
+		}
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	g.queue = kept
+	return updates
+}
+
+// 合并成员变更，调用方需持有g.mu
+func (g *Gossip) merge(m Member) {
+	if m.Addr == g.self.Addr {
+		// 其他成员怀疑本成员时，递增incarnation反驳
+		if m.State != MemberAlive && m.Incarnation >= g.self.Incarnation && g.self.State == MemberAlive {
+			g.self.Incarnation = m.Incarnation + 1
+			g.enqueue(*g.self)
+		}
+		return
+	}
+	old, ok := g.members[m.Addr]
+	if ok && !m.supersedes(old) {
+		return
+	}
+	cp := m
+	g.members[m.Addr] = &cp
+	if m.State == MemberSuspect {
+		if !ok || old.State != MemberSuspect {
+			g.suspects[m.Addr] = time.Now()
+		}
+	} else {
+		delete(g.suspects, m.Addr)
+	}
+	if m.State == MemberDead {
+		if !ok || old.State != MemberDead {
+			g.dead[m.Addr] = time.Now()
+		}
+	} else {
+		delete(g.dead, m.Addr)
+	}
+	g.enqueue(m)
+}
+
+func (g *Gossip) sendTo(addr string, msg *gossipMsg) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return
+	}
+	g.send(udpAddr, msg)
+}
+
+func (g *Gossip) send(addr *net.UDPAddr, msg *gossipMsg) {
+	if msg.Type == msgSync || msg.Type == msgSyncAck {
+		for _, part := range splitSync(msg) {
+			g.write(addr, part)
+		}
+		return
+	}
+	g.mu.Lock()
+	msg.Updates = g.piggyback()
+	g.mu.Unlock()
+	g.write(addr, msg)
+}
+
+// 全量状态可能超过一个UDP报文，按编码后的大小拆分为多个消息。sync的前几片以sync-ack发送，
+// 接收方只合并不回复，最后一片仍为sync，使对方只回复一次
+func splitSync(msg *gossipMsg) []*gossipMsg {
+	const overhead = 64 // 消息中除成员列表外的部分
+	var parts []*gossipMsg
+	var updates []Member
+	size := overhead
+	for _, m := range msg.Updates {
+		data, err := json.Marshal(m)
+		if err != nil {
+			continue
+		}
+		if len(updates) > 0 && size+len(data)+1 > maxPacketSize {
+			parts = append(parts, &gossipMsg{Type: msgSyncAck, Updates: updates})
+			updates, size = nil, overhead
+		}
+		updates = append(updates, m)
+		size += len(data) + 1
+	}
+	return append(parts, &gossipMsg{Type: msg.Type, Updates: updates})
+}
+
+func (g *Gossip) write(addr *net.UDPAddr, msg *gossipMsg) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	if len(data) > maxPacketSize {
+		log.Println("rpc registry: gossip message too large:", len(data))
+		return
+	}
+	_, _ = g.conn.WriteToUDP(data, addr)
+}
+
+func (g *Gossip) readLoop() {
+	defer g.done.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := g.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-g.stop:
+				return
+			default:
+				continue
+			}
+		}
+		var msg gossipMsg
+		if err = json.Unmarshal(buf[:n], &msg); err != nil {
+			continue
+		}
+		g.handle(from, &msg)
+	}
+}
+
+func (g *Gossip) handle(from *net.UDPAddr, msg *gossipMsg) {
+	g.mu.Lock()
+	for _, m := range msg.Updates {
+		g.merge(m)
+	}
+	g.mu.Unlock()
+	switch msg.Type {
+	case msgPing:
+		g.send(from, &gossipMsg{Type: msgAck, Seq: msg.Seq})
+	case msgAck:
+		g.mu.Lock()
+		if ch, ok := g.acks[msg.Seq]; ok {
+			close(ch)
+			delete(g.acks, msg.Seq)
+		}
+		r, ok := g.relays[msg.Seq]
+		delete(g.relays, msg.Seq)
+		g.mu.Unlock()
+		if ok { // 代为探测成功，转发给请求方
+			g.send(r.from, &gossipMsg{Type: msgAck, Seq: r.seq})
+		}
+	case msgPingReq:
+		g.mu.Lock()
+		g.seq++
+		seq := g.seq
+		g.relays[seq] = relay{from: from, seq: msg.Seq, at: time.Now()}
+		g.mu.Unlock()
+		g.sendTo(msg.Target, &gossipMsg{Type: msgPing, Seq: seq})
+	case msgSync:
+		g.send(from, &gossipMsg{Type: msgSyncAck, Updates: g.snapshot()})
+	}
+}
+
+func (g *Gossip) probeLoop() {
+	defer g.done.Done()
+	t := time.NewTicker(g.cfg.ProbeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-t.C:
+		}
+		g.expire()
+		target, ok := g.nextTarget()
+		if !ok {
+			g.join() // 尚未加入集群，重试种子
+			continue
+		}
+		g.rounds++
+		if g.rounds%g.cfg.SyncEvery == 0 {
+			g.sendTo(target, &gossipMsg{Type: msgSync, Updates: g.snapshot()})
+		}
+		g.probe(target)
+	}
+}
+
+// 将超时未反驳的suspect判定为dead，删除下线超过DeadTimeout的成员，并清理过期的代为探测记录
+func (g *Gossip) expire() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for addr, since := range g.suspects {
+		if now.Sub(since) < g.cfg.SuspectTimeout {
+			continue
+		}
+		delete(g.suspects, addr)
+		if m := g.members[addr]; m != nil && m.State == MemberSuspect {
+			m.State = MemberDead
+			g.dead[addr] = now
+			g.enqueue(*m)
+		}
+	}
+	for addr, since := range g.dead {
+		if now.Sub(since) >= g.cfg.DeadTimeout {
+			delete(g.dead, addr)
+			delete(g.members, addr)
+		}
+	}
+	for seq, r := range g.relays {
+		if now.Sub(r.at) > g.cfg.ProbeInterval {
+			delete(g.relays, seq)
+		}
+	}
+}
+
+// 按随机打乱的顺序轮流探测所有成员
+func (g *Gossip) nextTarget() (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for {
+		if len(g.order) == 0 {
+			for addr, m := range g.members {
+				if m != g.self && m.State != MemberDead {
+					g.order = append(g.order, addr)
+				}
+			}
+			if len(g.order) == 0 {
+				return "", false
+			}
+			g.r.Shuffle(len(g.order), func(i, j int) { g.order[i], g.order[j] = g.order[j], g.order[i] })
+		}
+		addr := g.order[0]
+		g.order = g.order[1:]
+		if m := g.members[addr]; m != nil && m.State != MemberDead {
+			return addr, true
+		}
+	}
+}
+
+func (g *Gossip) probe(target string) {
+	g.mu.Lock()
+	g.seq++
+	seq := g.seq
+	ack := make(chan struct{})
+	g.acks[seq] = ack
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		delete(g.acks, seq)
+		g.mu.Unlock()
+	}()
+
+	g.sendTo(target, &gossipMsg{Type: msgPing, Seq: seq})
+	select {
+	case <-ack:
+		return
+	case <-g.stop:
+		return
+	case <-time.After(g.cfg.ProbeTimeout):
+	}
+	for _, helper := range g.helpers(target) {
+		g.sendTo(helper, &gossipMsg{Type: msgPingReq, Seq: seq, Target: target})
+	}
+	select {
+	case <-ack:
+		return
+	case <-g.stop:
+		return
+	case <-time.After(g.cfg.ProbeInterval - g.cfg.ProbeTimeout):
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if m := g.members[target]; m != nil && m.State == MemberAlive {
+		suspect := *m
+		suspect.State = MemberSuspect
+		g.merge(suspect)
+	}
+}
+
+// 随机选出代为探测target的成员
+func (g *Gossip) helpers(target string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var candidates []string
+	for addr, m := range g.members {
+		if m != g.self && addr != target && m.State == MemberAlive {
+			candidates = append(candidates, addr)
+		}
+	}
+	g.r.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > g.cfg.IndirectChecks {
+		candidates = candidates[:g.cfg.IndirectChecks]
+	}
+	return candidates
+}
+
+// GossipDiscovery 以观察者身份加入gossip集群的Discovery，无需中心化的注册中心
+type GossipDiscovery struct {
+	*MultiServersDiscovery
+	gossip *Gossip
+}
+
+var _ ServiceDiscovery = (*GossipDiscovery)(nil)
+
+// NewGossipDiscovery 以cfg加入集群，cfg.Seeds为任意服务端的gossip地址
+func NewGossipDiscovery(cfg GossipConfig) (*GossipDiscovery, error) {
+	g, err := NewGossip(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &GossipDiscovery{
+		MultiServersDiscovery: NewMultiServersDiscovery(nil),
+		gossip:                g,
+	}, nil
+}
+
+// Refresh 以当前存活的服务端更新服务器列表
+func (d *GossipDiscovery) Refresh() error {
+	var servers []string
+	for _, m := range d.gossip.Members() {
+		if m.RPCAddr != "" {
+			servers = append(servers, m.RPCAddr)
+		}
+	}
+	return d.MultiServersDiscovery.Update(servers)
+}
+
+func (d *GossipDiscovery) Update(servers []string) error {
+	return errors.New("rpc registry: gossip discovery can't be updated manually")
+}
+
+func (d *GossipDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.Get(mode)
+}
+
+func (d *GossipDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
+
+// Servers 返回提供serviceMethod的存活服务端及其元数据，serviceMethod为空时返回全部服务端
+func (d *GossipDiscovery) Servers(serviceMethod string) ([]ServerItem, error) {
+	var items []ServerItem
+	for _, m := range d.gossip.Members() {
+		if m.RPCAddr == "" {
+			continue
+		}
+		i := sort.SearchStrings(m.Methods, serviceMethod)
+		if serviceMethod == "" || i < len(m.Methods) && m.Methods[i] == serviceMethod {
+			items = append(items, ServerItem{Addr: m.RPCAddr, Meta: m.Meta, versions: m.Versions})
+		}
+	}
+	return items, nil
+}
+
+func (d *GossipDiscovery) Close() error {
+	return d.gossip.Leave()
+}
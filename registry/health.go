@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// HealthCheckMethod 主动健康检查调用的标准方法，参数为服务名（空表示整个服务器），应答为状态字符串
+const HealthCheckMethod = "Health.Check"
+
+// 连续探测失败unhealthyThreshold次后将服务器标记为不健康
+const unhealthyThreshold = 2
+
+// Prober 探测服务器是否健康，addr形如"tcp@127.0.0.1:8080"，返回nil表示健康。
+// 注册中心不依赖客户端实现，可使用client.ProbeHealth
+type Prober func(ctx context.Context, addr string) error
+
+// HealthCheck 每隔period主动探测所有已注册的服务器，每次探测的超时为timeout。
+// 心跳正常但无法响应调用的服务器（如业务协程卡死）将不再被发现，探测成功后恢复。
+// 调用Close后停止探测
+func (r *ZRegistry) HealthCheck(prober Prober, period, timeout time.Duration) {
+	r.mu.Lock()
+	if r.healthStop == nil {
+		r.healthStop = make(chan struct{})
+	}
+	stop := r.healthStop
+	r.mu.Unlock()
+	go func() {
+		t := time.NewTicker(period)
+		defer t.Stop()
+		r.healthLoop(t.C, stop, prober, timeout)
+	}()
+}
+
+// 每收到一次tick探测一轮，stop关闭后返回
+func (r *ZRegistry) healthLoop(tick <-chan time.Time, stop chan struct{}, prober Prober, timeout time.Duration) {
+	for {
+		select {
+		case <-tick:
+			r.probeAll(prober, timeout)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *ZRegistry) probeAll(prober Prober, timeout time.Duration) {
+	r.mu.Lock()
+	addrs := make([]string, 0, len(r.servers))
+	for addr := range r.servers {
+		addrs = append(addrs, addr)
+	}
+	r.mu.Unlock()
+
+	results := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			results[i] = prober(ctx, addr)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, addr := range addrs {
+		s, ok := r.servers[addr]
+		if !ok {
+			continue
+		}
+		if results[i] == nil {
+			if s.failures >= unhealthyThreshold {
+				log.Println("rpc registry: server recovered:", addr)
+			}
+			s.failures = 0
+			continue
+		}
+		s.failures++
+		if s.failures == unhealthyThreshold {
+			log.Println("rpc registry: server unhealthy:", addr, results[i])
+		}
+	}
+}
+
+// 调用方需持有r.mu
+func (s *ServerItem) healthy() bool {
+	return s.failures < unhealthyThreshold
+}
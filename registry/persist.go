@@ -0,0 +1,266 @@
+package registry
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+	"zrpc/balancer"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "wal.log"
+)
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+	opRules  = "rules"
+)
+
+// 预写日志中的一条记录，每行一个json
+type walEntry struct {
+	Op           string            `json:"op"`
+	Addr         string            `json:"addr,omitempty"`
+	Methods      []string          `json:"methods,omitempty"`
+	Versions     map[string]string `json:"versions,omitempty"`
+	Meta         map[string]string `json:"meta,omitempty"`
+	Rules        []balancer.Rule   `json:"rules,omitempty"`
+	RulesVersion int64             `json:"rules_version,omitempty"`
+}
+
+type snapshot struct {
+	Servers      []walEntry      `json:"servers"`
+	Rules        []balancer.Rule `json:"rules"`
+	RulesVersion int64           `json:"rules_version,omitempty"`
+}
+
+// Persist 将注册信息持久化到dir：每次变更追加写入预写日志，每隔snapshotPeriod（需大于0）生成快照并清空日志。
+// 启动时先从快照与日志恢复，恢复的服务器标记为Stale，仍可被发现，
+// 在超时时间内收到心跳即确认，否则与普通服务器一样被移除
+func (r *ZRegistry) Persist(dir string, snapshotPeriod time.Duration) error {
+	if snapshotPeriod <= 0 {
+		return errors.New("rpc registry: snapshot period must be positive")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		return errors.New("rpc registry: persistence already enabled")
+	}
+	if err := r.restore(dir); err != nil {
+		return err
+	}
+	r.dir = dir
+	// 立即生成快照，合并恢复时读取的日志
+	if err := r.snapshot(); err != nil {
+		return err
+	}
+	r.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		t := time.NewTicker(snapshotPeriod)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				r.mu.Lock()
+				// 等待锁期间可能已经Close，此时不能再重新打开日志
+				select {
+				case <-stop:
+					r.mu.Unlock()
+					return
+				default:
+				}
+				if err := r.snapshot(); err != nil {
+					log.Println("rpc registry: snapshot error:", err)
+				}
+				r.mu.Unlock()
+			case <-stop:
+				return
+			}
+		}
+	}(r.stop)
+	return nil
+}
+
+// Close 停止主动健康检查、集群同步与生成快照，并关闭预写日志
+func (r *ZRegistry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.healthStop != nil {
+		close(r.healthStop)
+		r.healthStop = nil
+	}
+	if r.syncStop != nil {
+		close(r.syncStop)
+		r.syncStop = nil
+	}
+	if r.stop == nil {
+		return nil
+	}
+	close(r.stop)
+	r.stop = nil
+	if r.wal == nil {
+		return nil
+	}
+	err := r.wal.Close()
+	r.wal = nil
+	return err
+}
+
+// 调用方需持有r.mu
+func (r *ZRegistry) logEntry(e *walEntry) {
+	if r.stop == nil { // 未开启持久化
+		return
+	}
+	if r.wal == nil {
+		// 上次生成快照后打开日志失败，重新打开并追加写入；重放快照之前的旧记录仍得到相同的状态
+		f, err := os.OpenFile(filepath.Join(r.dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Println("rpc registry: open wal error:", err)
+			return
+		}
+		r.wal = f
+	}
+	data, err := json.Marshal(e)
+	if err == nil {
+		_, err = r.wal.Write(append(data, '\n'))
+	}
+	if err != nil {
+		log.Println("rpc registry: write wal error:", err)
+	}
+}
+
+// 判断addr是否已以相同的方法、版本与元数据注册，调用方需持有r.mu
+func (r *ZRegistry) registered(addr string, methods []string, versions, meta map[string]string) bool {
+	s, ok := r.servers[addr]
+	if !ok {
+		return false
+	}
+	old := append([]string(nil), r.server2service[addr]...)
+	cur := append([]string(nil), methods...)
+	sort.Strings(old)
+	sort.Strings(cur)
+	return reflect.DeepEqual(old, cur) && equalMap(s.versions, versions) && equalMap(s.Meta, meta)
+}
+
+func equalMap(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 写入快照后清空日志，调用方需持有r.mu。
+// 快照写入临时文件后再重命名，清空日志前崩溃时重放旧日志仍得到相同的状态
+func (r *ZRegistry) snapshot() error {
+	data, err := json.Marshal(r.state())
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(r.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(r.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if r.wal != nil {
+		_ = r.wal.Close()
+	}
+	r.wal, err = os.OpenFile(filepath.Join(r.dir, walFile), os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// 当前的全部注册信息与路由规则，调用方需持有r.mu
+func (r *ZRegistry) state() snapshot {
+	snap := snapshot{Rules: r.rules, RulesVersion: r.rulesVersion}
+	for addr, s := range r.servers {
+		snap.Servers = append(snap.Servers, walEntry{
+			Op:       opPut,
+			Addr:     addr,
+			Methods:  r.server2service[addr],
+			Versions: s.versions,
+			Meta:     s.Meta,
+		})
+	}
+	sort.Slice(snap.Servers, func(i, j int) bool { return snap.Servers[i].Addr < snap.Servers[j].Addr })
+	return snap
+}
+
+// 依次应用快照与日志，调用方需持有r.mu
+func (r *ZRegistry) restore(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var snap snapshot
+		if err = json.Unmarshal(data, &snap); err != nil {
+			return errors.New("rpc registry: corrupted snapshot: " + err.Error())
+		}
+		for i := range snap.Servers {
+			r.apply(&snap.Servers[i])
+		}
+		r.rules, r.rulesVersion = snap.Rules, snap.RulesVersion
+	}
+	f, err := os.Open(filepath.Join(dir, walFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil // 最后一行不完整说明写入时崩溃，丢弃
+		}
+		if err != nil {
+			return err
+		}
+		var e walEntry
+		if err = json.Unmarshal(line, &e); err != nil {
+			return errors.New("rpc registry: corrupted wal: " + err.Error())
+		}
+		r.apply(&e)
+	}
+}
+
+func (r *ZRegistry) apply(e *walEntry) {
+	switch e.Op {
+	case opPut:
+		r.put(e.Addr, e.Methods, e.Versions, e.Meta)
+		r.servers[e.Addr].Stale = true
+	case opDelete:
+		r.deleteServer(e.Addr)
+	case opRules:
+		r.rules, r.rulesVersion = e.Rules, e.RulesVersion
+	}
+}
@@ -1,9 +1,13 @@
 package registry
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,11 +21,30 @@ type ZRegistry struct {
 	services       map[string]map[string]bool
 	server2service map[string][]string
 	bx             *balancer.BalancerX
+	rules          []balancer.Rule // 路由规则，随发现请求下发给客户端
+	rulesVersion   int64           // 规则的版本号，集群中以最大者为准
+	wal            *os.File        // 预写日志，打开失败时为nil，下次写入时重试
+	dir            string
+	stop           chan struct{} // 开启持久化时非nil，关闭时停止生成快照
+	healthStop     chan struct{} // 关闭时停止主动健康检查
+	peers          []string      // 集群中的其他节点
+	syncPeriod     time.Duration // 与其他节点全量同步的间隔
+	syncStop       chan struct{} // 关闭时停止定期同步
+	peerClient     *http.Client
 }
 
 type ServerItem struct {
-	Addr  string
-	start time.Time
+	Addr     string
+	Meta     map[string]string // 服务器上报的元数据，如权重、机房、标签
+	start    time.Time
+	versions map[string]string // 服务名 -> 该服务器上的服务版本
+	failures int               // 主动健康检查连续失败的次数
+	Stale    bool              `json:",omitempty"` // 从持久化数据恢复、尚未被心跳确认
+}
+
+// Version 返回服务器上报的服务版本，未上报时为空
+func (s ServerItem) Version(service string) string {
+	return s.versions[service]
 }
 
 const (
@@ -36,22 +59,49 @@ func New(timeout time.Duration) *ZRegistry {
 		bx:             balancer.DefaultBalancerX,
 		services:       map[string]map[string]bool{},
 		server2service: map[string][]string{},
+		peerClient:     &http.Client{Timeout: 3 * time.Second},
+		syncPeriod:     DefaultPeerSyncPeriod,
 	}
 }
 
 var DefaultZRegister = New(DefaultTimeout)
 
-func (r *ZRegistry) putServer(addr string, methods []string) {
+func (r *ZRegistry) putServer(addr string, methods []string, versions, meta map[string]string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	// 心跳只刷新时间，注册信息有变化时才写入日志
+	if !r.registered(addr, methods, versions, meta) {
+		r.logEntry(&walEntry{Op: opPut, Addr: addr, Methods: methods, Versions: versions, Meta: meta})
+	}
+	r.put(addr, methods, versions, meta)
+	r.servers[addr].Stale = false
+}
+
+// 调用方需持有r.mu
+func (r *ZRegistry) put(addr string, methods []string, versions, meta map[string]string) {
 	s := r.servers[addr]
 	if s == nil {
 		r.servers[addr] = &ServerItem{
-			addr,
-			time.Now(),
+			Addr:     addr,
+			Meta:     meta,
+			start:    time.Now(),
+			versions: versions,
 		}
 	} else {
 		s.start = time.Now()
+		s.versions = versions
+		s.Meta = meta
+	}
+	r.bx.UpdateMeta(addr, meta)
+	// 服务端已注销的方法不再路由到该服务器
+	current := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		current[m] = true
+	}
+	for _, m := range r.server2service[addr] {
+		if !current[m] {
+			delete(r.services[m], addr)
+		}
 	}
 	for i := range methods {
 		if v, ok := r.services[methods[i]]; ok {
@@ -66,20 +116,32 @@ func (r *ZRegistry) putServer(addr string, methods []string) {
 	r.server2service[addr] = methods
 }
 
-func (r *ZRegistry) aliveServers(method string) []string {
+// constraint非nil时只返回该服务版本满足约束的服务器，method为空时返回所有存活的服务器，
+// 主动健康检查判定为不健康的服务器不会返回
+func (r *ZRegistry) aliveServers(method string, constraint *Constraint) []string {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	var alive []string
-	if servers, ok := r.services[method]; ok {
+	servers, ok := r.services[method]
+	if method == "" {
+		servers, ok = make(map[string]bool, len(r.servers)), true
+		for addr := range r.servers {
+			servers[addr] = true
+		}
+	}
+	if ok {
 		for server, _ := range servers {
 			if r.timeout == 0 || r.servers[server].start.Add(r.timeout).After(time.Now()) {
+				if !r.servers[server].healthy() {
+					continue
+				}
+				if constraint != nil && !constraint.Check(r.servers[server].versions[serviceOf(method)]) {
+					continue
+				}
 				alive = append(alive, server)
 			} else {
-				for _, v := range r.server2service[server] {
-					delete(r.services[v], server)
-				}
-				delete(r.server2service, server)
-				delete(r.servers, server)
+				r.logEntry(&walEntry{Op: opDelete, Addr: server})
+				r.deleteServer(server)
 			}
 		}
 	}
@@ -87,29 +149,170 @@ func (r *ZRegistry) aliveServers(method string) []string {
 	return alive
 }
 
+// 调用方需持有r.mu
+func (r *ZRegistry) deleteServer(addr string) {
+	for _, v := range r.server2service[addr] {
+		delete(r.services[v], addr)
+	}
+	delete(r.server2service, addr)
+	delete(r.servers, addr)
+}
+
+// SetRules 替换全部路由规则，客户端在下一次发现时生效
+func (r *ZRegistry) SetRules(rules []balancer.Rule) error {
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	version := time.Now().UnixNano()
+	if version <= r.rulesVersion {
+		version = r.rulesVersion + 1
+	}
+	r.setRules(rules, version)
+	return nil
+}
+
+func (r *ZRegistry) Rules() []balancer.Rule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]balancer.Rule(nil), r.rules...)
+}
+
+func (r *ZRegistry) rulesFor(method string) []balancer.Rule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rules []balancer.Rule
+	for _, rule := range r.rules {
+		if rule.AppliesTo(method) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// 返回服务器及其元数据的副本
+func (r *ZRegistry) serverItems(addrs []string) []ServerItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	items := make([]ServerItem, 0, len(addrs))
+	for _, addr := range addrs {
+		if s, ok := r.servers[addr]; ok {
+			meta := make(map[string]string, len(s.Meta))
+			for k, v := range s.Meta {
+				meta[k] = v
+			}
+			items = append(items, ServerItem{Addr: addr, Meta: meta, Stale: s.Stale})
+		}
+	}
+	return items
+}
+
 func (r *ZRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
+		if req.Header.Get(syncHeader) != "" { // 集群中的其他节点同步全量状态
+			r.mu.Lock()
+			snap := r.state()
+			r.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(snap)
+			return
+		}
 		mth := req.Header.Get("X-Zrpc-Services")
-		alive := r.aliveServers(mth)
-		mode := req.Header.Get("X-Zrpc-Mode")
-		target := r.bx.Next(mode, mth, req.RemoteAddr, alive)
-		log.Println("remote addr:" + req.RemoteAddr + ", target addr:" + target)
-		w.Header().Set("X-Zrpc-Servers", target)
+		var constraint *Constraint
+		if raw := req.Header.Get("X-Zrpc-Version"); raw != "" {
+			var err error
+			if constraint, err = ParseConstraint(raw); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		alive := r.aliveServers(mth, constraint)
+		// XClient在本地选择服务器；只有指定了X-Zrpc-Mode的旧客户端才由注册中心代为选择
+		if mode := req.Header.Get("X-Zrpc-Mode"); mode != "" {
+			r.bx.UpdateMembers(mth, alive)
+			target := r.bx.Next(mode, mth, req.RemoteAddr, alive)
+			log.Println("remote addr:" + req.RemoteAddr + ", target addr:" + target)
+			w.Header().Set("X-Zrpc-Servers", target)
+		}
+		if rules := r.rulesFor(mth); len(rules) > 0 {
+			if data, err := json.Marshal(rules); err == nil {
+				w.Header().Set("X-Zrpc-Rules", string(data))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
+		// 响应体中返回所有可用服务器及其元数据，供客户端自行选择
+		_ = json.NewEncoder(w).Encode(r.serverItems(alive))
 	case http.MethodPost:
 		addr := req.Header.Get("X-Zrpc-Servers")
-		mths := strings.Split(req.Header.Get("X-Zrpc-Services"), ",")
+		mths := make([]string, 0)
+		for _, mth := range strings.Split(req.Header.Get("X-Zrpc-Services"), ",") {
+			if mth != "" {
+				mths = append(mths, mth)
+			}
+		}
 		if addr == "" {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		r.putServer(addr, mths)
+		meta := map[string]string{}
+		if values, err := url.ParseQuery(req.Header.Get("X-Zrpc-Meta")); err == nil {
+			for k := range values {
+				meta[k] = values.Get(k)
+			}
+		}
+		r.putServer(addr, mths, parseVersions(req.Header.Get("X-Zrpc-Versions")), meta)
+		if req.Header.Get(replicatedHeader) == "" {
+			r.replicate(http.MethodPost, req.Header, nil)
+		}
+	case http.MethodPut: // 更新路由规则，请求体为规则的json数组
+		var rules []balancer.Rule
+		if err := json.NewDecoder(req.Body).Decode(&rules); err != nil {
+			http.Error(w, "rpc registry: invalid rules: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Header.Get(replicatedHeader) != "" {
+			r.mu.Lock()
+			r.setRules(rules, parseRulesVersion(req.Header.Get(rulesVersionHeader)))
+			r.mu.Unlock()
+			return
+		}
+		if err := r.SetRules(rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, _ := json.Marshal(rules)
+		r.mu.Lock()
+		header := http.Header{rulesVersionHeader: {strconv.FormatInt(r.rulesVersion, 10)}}
+		r.mu.Unlock()
+		r.replicate(http.MethodPut, header, body)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
+func serviceOf(method string) string {
+	if dot := strings.LastIndex(method, "."); dot >= 0 {
+		return method[:dot]
+	}
+	return method
+}
+
+// 解析心跳中的 "Foo=1.2.0,billing.v2.Invoice=2.0.1"
+func parseVersions(header string) map[string]string {
+	versions := map[string]string{}
+	for _, item := range strings.Split(header, ",") {
+		if eq := strings.LastIndex(item, "="); eq > 0 {
+			versions[strings.TrimSpace(item[:eq])] = strings.TrimSpace(item[eq+1:])
+		}
+	}
+	return versions
+}
+
 func (r *ZRegistry) HandleHTTP(registryPath string) {
 	http.Handle(registryPath, r)
 	log.Println("rpc registry path:", registryPath)
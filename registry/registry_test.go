@@ -0,0 +1,546 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zrpc/balancer"
+)
+
+func heartbeat(url, addr, services, meta string) {
+	req, _ := http.NewRequest(http.MethodPost, url, nil)
+	req.Header.Set("X-Zrpc-Servers", addr)
+	req.Header.Set("X-Zrpc-Services", services)
+	req.Header.Set("X-Zrpc-Meta", meta)
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil, "heartbeat error: %v", err)
+	_ = resp.Body.Close()
+}
+
+func TestZRegistry_Meta(t *testing.T) {
+	ts := httptest.NewServer(New(DefaultTimeout))
+	defer ts.Close()
+	heartbeat(ts.URL, "tcp@a", "Foo.Sum", "weight=3&zone=us-east-1a")
+	heartbeat(ts.URL, "tcp@b", "Foo.Sum,Bar.Get", "weight=1&zone=us-west-2b&team=billing")
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("X-Zrpc-Services", "Foo.Sum")
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil, "discover error: %v", err)
+	var items []ServerItem
+	_ = json.NewDecoder(resp.Body).Decode(&items)
+	_ = resp.Body.Close()
+	_assert(len(items) == 2 && items[0].Addr == "tcp@a" && items[0].Meta["weight"] == "3", "unexpected items %v", items)
+	_assert(items[1].Meta["team"] == "billing" && items[1].Meta["zone"] == "us-west-2b", "unexpected items %v", items)
+	_assert(resp.Header.Get("X-Zrpc-Servers") == "", "expect no server-side selection without X-Zrpc-Mode")
+	req.Header.Set("X-Zrpc-Mode", "RoundRobin")
+	resp, err = http.DefaultClient.Do(req)
+	_assert(err == nil && resp.Header.Get("X-Zrpc-Servers") != "", "expect legacy clients to get a selected server")
+	_ = resp.Body.Close()
+
+	d := NewZRegistryDiscovery(ts.URL, 0)
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 2, "expect all alive servers, got %v, err %v", servers, err)
+	_assert(d.Meta("tcp@b")["team"] == "billing", "expect metadata from discovery")
+
+	heartbeat(ts.URL, "tcp@b", "Foo.Sum", "weight=5")
+	d = NewZRegistryDiscovery(ts.URL, 0)
+	_assert(d.Meta("tcp@b")["weight"] == "5" && d.Meta("tcp@b")["team"] == "", "expect metadata to be replaced")
+}
+
+func TestZRegistry_Rules(t *testing.T) {
+	ts := httptest.NewServer(New(DefaultTimeout))
+	defer ts.Close()
+	heartbeat(ts.URL, "tcp@a", "Foo.Sum,Bar.Get", "canary=true")
+	put := func(body string) int {
+		req, _ := http.NewRequest(http.MethodPut, ts.URL, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "put error: %v", err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	_assert(put(`[{"method":"Foo","subset":{"canary":"true"},"percent":5}]`) == http.StatusOK, "expect rules accepted")
+	_assert(put(`[{"method":"Foo","percent":5}]`) == http.StatusBadRequest, "expect rule without subset rejected")
+
+	for method, want := range map[string]int{"Foo.Sum": 1, "Bar.Get": 0} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("X-Zrpc-Services", method)
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "discover error: %v", err)
+		_ = resp.Body.Close()
+		var rules []balancer.Rule
+		if raw := resp.Header.Get("X-Zrpc-Rules"); raw != "" {
+			_ = json.Unmarshal([]byte(raw), &rules)
+		}
+		_assert(len(rules) == want, "%s: expect %d rules, got %v", method, want, rules)
+	}
+}
+
+func TestZRegistry_HealthCheck(t *testing.T) {
+	r := New(DefaultTimeout)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	heartbeat(ts.URL, "tcp@a", "Foo.Sum", "")
+	heartbeat(ts.URL, "tcp@b", "Foo.Sum", "")
+	hung := map[string]bool{"tcp@b": true}
+	prober := func(ctx context.Context, addr string) error {
+		if hung[addr] {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	r.probeAll(prober, 10*time.Millisecond)
+	_assert(len(r.aliveServers("Foo.Sum", nil)) == 2, "expect a single failure to be tolerated")
+	r.probeAll(prober, 10*time.Millisecond)
+	alive := r.aliveServers("Foo.Sum", nil)
+	_assert(len(alive) == 1 && alive[0] == "tcp@a", "expect hung server excluded, got %v", alive)
+	heartbeat(ts.URL, "tcp@b", "Foo.Sum", "")
+	_assert(len(r.aliveServers("Foo.Sum", nil)) == 1, "expect heartbeat not to override failed probes")
+
+	hung["tcp@b"] = false
+	r.probeAll(prober, 10*time.Millisecond)
+	_assert(len(r.aliveServers("Foo.Sum", nil)) == 2, "expect recovered server back")
+
+	// 以手动的tick驱动探测，Close后探测循环退出
+	var probes int32
+	tick, done := make(chan time.Time), make(chan struct{})
+	r.HealthCheck(prober, time.Hour, time.Millisecond) // 创建healthStop
+	r.mu.Lock()
+	stop := r.healthStop
+	r.mu.Unlock()
+	go func() {
+		r.healthLoop(tick, stop, func(ctx context.Context, addr string) error {
+			atomic.AddInt32(&probes, 1)
+			return nil
+		}, time.Millisecond)
+		close(done)
+	}()
+	tick <- time.Now()
+	tick <- time.Now() // 第二次发送成功时第一轮探测已经结束
+	_ = r.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expect health check stopped after Close")
+	}
+	_assert(atomic.LoadInt32(&probes) >= 2, "expect one probe per server each round, got %d", probes)
+}
+
+func TestZRegistry_Persist(t *testing.T) {
+	dir := t.TempDir()
+	r := New(DefaultTimeout)
+	_assert(r.Persist(dir, 0) != nil, "expect error for non-positive snapshot period")
+	_assert(r.Persist(dir, time.Hour) == nil, "persist error")
+	ts := httptest.NewServer(r)
+	heartbeat(ts.URL, "tcp@a", "Foo.Sum", "weight=3")
+	heartbeat(ts.URL, "tcp@b", "Foo.Sum,Bar.Get", "")
+	heartbeat(ts.URL, "tcp@b", "Bar.Get", "")
+	_ = r.SetRules([]balancer.Rule{{Method: "Foo", Subset: map[string]string{"canary": "true"}, Percent: 5}})
+	ts.Close()
+	_ = r.Close()
+
+	// 模拟重启：从快照与日志恢复，服务器在收到心跳前标记为Stale
+	r = New(DefaultTimeout)
+	_assert(r.Persist(dir, time.Hour) == nil, "restore error")
+	ts = httptest.NewServer(r)
+	defer ts.Close()
+	discover := func(method string) []ServerItem {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("X-Zrpc-Services", method)
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "discover error: %v", err)
+		var items []ServerItem
+		_ = json.NewDecoder(resp.Body).Decode(&items)
+		_ = resp.Body.Close()
+		return items
+	}
+	items := discover("Foo.Sum")
+	_assert(len(items) == 1 && items[0].Addr == "tcp@a" && items[0].Stale && items[0].Meta["weight"] == "3", "unexpected items %+v", items)
+	_assert(len(discover("Bar.Get")) == 1 && len(r.Rules()) == 1, "expect registrations and rules restored")
+	heartbeat(ts.URL, "tcp@a", "Foo.Sum", "weight=3")
+	items = discover("Foo.Sum")
+	_assert(len(items) == 1 && !items[0].Stale, "expect heartbeat to confirm restored server")
+	_ = r.Close()
+
+	// 崩溃时写了一半的日志行被丢弃
+	f, _ := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = f.WriteString(`{"op":"put","addr":"tcp@c"`)
+	_ = f.Close()
+	r = New(DefaultTimeout)
+	_assert(r.Persist(dir, time.Hour) == nil, "expect torn write to be ignored")
+	_assert(len(r.aliveServers("", nil)) == 2, "expect two restored servers")
+
+	// 生成快照时打开日志失败，之后的变更仍写入日志，Close照常停止生成快照
+	r.mu.Lock()
+	_ = r.wal.Close()
+	r.wal = nil
+	stop := r.stop
+	r.mu.Unlock()
+	ts2 := httptest.NewServer(r)
+	heartbeat(ts2.URL, "tcp@d", "Foo.Sum", "")
+	ts2.Close()
+	_ = r.Close()
+	select {
+	case <-stop:
+	default:
+		t.Fatal("expect Close to stop snapshots")
+	}
+	r = New(DefaultTimeout)
+	_assert(r.Persist(dir, time.Hour) == nil, "restore error")
+	_assert(len(r.aliveServers("", nil)) == 3, "expect the change after a failed open to be restored")
+	_ = r.Close()
+
+	// 快照周期很短时，Close之后不会再有快照重新打开日志
+	r = New(DefaultTimeout)
+	_assert(r.Persist(dir, time.Millisecond) == nil, "persist error")
+	time.Sleep(5 * time.Millisecond)
+	_ = r.Close()
+	time.Sleep(5 * time.Millisecond)
+	r.mu.Lock()
+	_assert(r.wal == nil, "expect no wal reopened after Close")
+	r.mu.Unlock()
+}
+
+func TestZRegistry_Cluster(t *testing.T) {
+	r1, r2 := New(DefaultTimeout), New(DefaultTimeout)
+	ts1, ts2 := httptest.NewServer(r1), httptest.NewServer(r2)
+	defer ts1.Close()
+	defer ts2.Close()
+	r1.SetPeers(ts2.URL)
+	r2.SetPeers(ts1.URL)
+	defer func() { _ = r1.Close() }()
+	defer func() { _ = r2.Close() }()
+	eventually := func(cond func() bool, msg string) {
+		for i := 0; i < 100 && !cond(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		_assert(cond(), msg)
+	}
+
+	heartbeat(ts1.URL, "tcp@a", "Foo.Sum", "")
+	eventually(func() bool { return len(r2.aliveServers("Foo.Sum", nil)) == 1 }, "expect heartbeat replicated to peer")
+	req, _ := http.NewRequest(http.MethodPut, ts2.URL, strings.NewReader(`[{"method":"Foo","subset":{"canary":"true"},"percent":5}]`))
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil && resp.StatusCode == http.StatusOK, "put rules error: %v", err)
+	_ = resp.Body.Close()
+	eventually(func() bool { return len(r1.Rules()) == 1 }, "expect rules replicated to peer")
+
+	// 新节点加入时同步全量状态
+	r3 := New(DefaultTimeout)
+	r3.SetPeers("http://127.0.0.1:1/registry", ts1.URL)
+	defer func() { _ = r3.Close() }()
+	_assert(len(r3.aliveServers("Foo.Sum", nil)) == 1 && len(r3.Rules()) == 1, "expect state synced from a live peer")
+
+	// 转发的旧版本规则不会覆盖新规则
+	req, _ = http.NewRequest(http.MethodPut, ts1.URL, strings.NewReader(`[]`))
+	req.Header.Set(replicatedHeader, "1")
+	req.Header.Set(rulesVersionHeader, "1")
+	resp, _ = http.DefaultClient.Do(req)
+	_ = resp.Body.Close()
+	_assert(len(r1.Rules()) == 1, "expect stale replicated rules to be ignored")
+
+	// 转发给r5的规则全部丢失，由定期全量同步补齐
+	r4, r5 := New(DefaultTimeout), New(DefaultTimeout)
+	ts4 := httptest.NewServer(r4)
+	defer ts4.Close()
+	ts5 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get(replicatedHeader) != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r5.ServeHTTP(w, req)
+	}))
+	defer ts5.Close()
+	r4.SetPeers(ts5.URL)
+	r5.SetPeerSyncPeriod(20 * time.Millisecond)
+	r5.SetPeers(ts4.URL)
+	defer func() { _ = r5.Close() }()
+	_ = r4.SetRules([]balancer.Rule{{Method: "Foo", Subset: map[string]string{"canary": "true"}, Percent: 5}})
+	heartbeat(ts4.URL, "tcp@b", "Foo.Sum", "")
+	eventually(func() bool { return len(r5.Rules()) == 1 }, "expect lost rules to be synced")
+	eventually(func() bool { return len(r5.aliveServers("Foo.Sum", nil)) == 1 }, "expect lost heartbeats to be synced")
+}
+
+func TestEndpoints_Failover(t *testing.T) {
+	ts := httptest.NewServer(New(DefaultTimeout))
+	defer ts.Close()
+	e := NewEndpoints("http://127.0.0.1:1/registry, " + ts.URL)
+	for i := 0; i < 2; i++ {
+		resp, err := e.Do(func(addr string) (*http.Request, error) {
+			return http.NewRequest(http.MethodGet, addr, nil)
+		})
+		_assert(err == nil && resp.StatusCode == http.StatusOK, "expect failover to the live registry, err %v", err)
+		_ = resp.Body.Close()
+	}
+	_assert(e.last == 1, "expect the live registry to be remembered")
+	_, err := NewEndpoints("http://127.0.0.1:1/registry").Do(func(addr string) (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, addr, nil)
+	})
+	_assert(err != nil, "expect error when all registries are down")
+}
+
+func TestGossipDiscovery(t *testing.T) {
+	cfg := GossipConfig{ProbeInterval: 50 * time.Millisecond, ProbeTimeout: 20 * time.Millisecond, SuspectTimeout: 300 * time.Millisecond, DeadTimeout: 500 * time.Millisecond}
+	waitFor := func(cond func() bool, msg string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			_assert(time.Now().Before(deadline), msg)
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	var nodes []*Gossip
+	for i := 0; i < 8; i++ {
+		c := cfg
+		if i > 0 {
+			c.Seeds = []string{nodes[i-1].Addr()} // 每个节点只知道前一个节点
+		}
+		g, err := NewGossip(c)
+		_assert(err == nil, "start gossip error: %v", err)
+		methods := []string{"Foo.Sum"}
+		if i%2 == 0 {
+			methods = append(methods, "Bar.Echo")
+		}
+		g.Advertise("tcp@server-"+string(rune('a'+i)), methods, nil, map[string]string{"zone": "z" + string(rune('0'+i%2))})
+		nodes = append(nodes, g)
+	}
+	defer func() {
+		for _, g := range nodes {
+			_ = g.Close()
+		}
+	}()
+	c := cfg
+	c.Seeds = []string{nodes[7].Addr()}
+	d, err := NewGossipDiscovery(c)
+	_assert(err == nil, "start observer error: %v", err)
+	defer func() { _ = d.Close() }()
+	servers := func(method string) []ServerItem {
+		items, err := d.Servers(method)
+		_assert(err == nil, "servers error: %v", err)
+		return items
+	}
+
+	t.Run("converge", func(t *testing.T) {
+		waitFor(func() bool {
+			servers, _ := d.GetAll()
+			return len(servers) == 8
+		}, "observer doesn't see all servers")
+		items := servers("Bar.Echo")
+		_assert(len(items) == 4 && items[0].Meta["zone"] == "z0", "unexpected Bar.Echo servers %v", items)
+		for _, g := range nodes {
+			waitFor(func() bool { return len(g.Members()) == 9 }, "member doesn't see the whole cluster")
+		}
+		_, err := d.Get(RoundRobinSelect)
+		_assert(err == nil, "get error: %v", err)
+		_assert(d.Update([]string{"tcp@x"}) != nil, "expect manual update to fail")
+	})
+	t.Run("advertise", func(t *testing.T) {
+		nodes[3].Advertise("tcp@server-d", []string{"Foo.Sum", "Baz.Ping"}, nil, nil)
+		waitFor(func() bool { return len(servers("Baz.Ping")) == 1 }, "advertised service not propagated")
+	})
+	t.Run("failure", func(t *testing.T) {
+		_ = nodes[5].Close() // 模拟进程崩溃，由其他成员探测发现
+		waitFor(func() bool { return len(servers("Foo.Sum")) == 7 }, "crashed server still discovered")
+		_ = nodes[6].Leave()
+		waitFor(func() bool { return len(servers("Foo.Sum")) == 6 }, "left server still discovered")
+		waitFor(func() bool { return len(nodes[0].Members()) == 7 }, "expect dead members removed")
+		// 超过DeadTimeout后，dead成员从成员表中删除，不再随同步消息发送
+		for _, g := range []*Gossip{nodes[0], d.gossip} {
+			waitFor(func() bool { return len(g.snapshot()) == 7 }, "expect dead members reaped")
+		}
+	})
+	t.Run("rejoin", func(t *testing.T) {
+		c := cfg
+		c.BindAddr = nodes[5].Addr() // 以相同地址重启，新的incarnation覆盖dead状态
+		c.Seeds = []string{nodes[0].Addr()}
+		g, err := NewGossip(c)
+		_assert(err == nil, "restart gossip error: %v", err)
+		nodes[5] = g
+		g.Advertise("tcp@server-f", []string{"Foo.Sum"}, nil, nil)
+		waitFor(func() bool { return len(servers("Foo.Sum")) == 7 }, "restarted server not discovered")
+	})
+}
+
+func TestGossip_LargeSync(t *testing.T) {
+	methods := make([]string, 40)
+	for i := range methods {
+		methods[i] = fmt.Sprintf("billing.v2.Invoice.Method%02d", i)
+	}
+	var members []Member
+	for i := 0; i < 300; i++ {
+		members = append(members, Member{Addr: fmt.Sprintf("10.0.%d.%d:7946", i/256, i%256), RPCAddr: fmt.Sprintf("tcp@10.0.%d.%d:8080", i/256, i%256), Methods: methods, Incarnation: 1})
+	}
+	parts := splitSync(&gossipMsg{Type: msgSync, Updates: members})
+	_assert(len(parts) > 1, "expect a large snapshot to be split")
+	total := 0
+	for i, part := range parts {
+		data, _ := json.Marshal(part)
+		_assert(len(data) <= maxPacketSize, "part %d too large: %d", i, len(data))
+		last := i == len(parts)-1
+		_assert(last == (part.Type == msgSync), "expect only the last part to ask for a reply, part %d is %s", i, part.Type)
+		total += len(part.Updates)
+	}
+	_assert(total == len(members), "expect %d members, got %d", len(members), total)
+
+	// 成员表超过一个报文时，新成员仍能通过同步得到全部成员
+	cfg := GossipConfig{ProbeInterval: time.Hour}
+	seed, err := NewGossip(cfg)
+	_assert(err == nil, "start gossip error: %v", err)
+	defer func() { _ = seed.Close() }()
+	seed.mu.Lock()
+	for _, m := range members {
+		seed.merge(m)
+	}
+	seed.mu.Unlock()
+	cfg.Seeds = []string{seed.Addr()}
+	g, err := NewGossip(cfg)
+	_assert(err == nil, "start gossip error: %v", err)
+	defer func() { _ = g.Close() }()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(g.Members()) != len(members)+2 {
+		_assert(time.Now().Before(deadline), "expect all %d members synced, got %d", len(members)+2, len(g.Members()))
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+type fakeResolver map[string][]*net.SRV
+
+type countingResolver struct {
+	Resolver
+	lookups int32
+}
+
+func (r *countingResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	atomic.AddInt32(&r.lookups, 1)
+	return r.Resolver.LookupSRV(ctx, service, proto, name)
+}
+
+func (r fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := r["_"+service+"._"+proto+"."+name]
+	if !ok {
+		return "", nil, errors.New("no such host")
+	}
+	return name, records, nil
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	resolver := fakeResolver{
+		"_zrpc._tcp.example.com": {
+			{Target: "a.example.com.", Port: 8001, Priority: 10, Weight: 3},
+			{Target: "b.example.com.", Port: 8001, Priority: 10},
+			{Target: "backup.example.com.", Port: 8001, Priority: 20, Weight: 1},
+		},
+		"_billing-v2-invoice._tcp.example.com": {{Target: "c.example.com.", Port: 9000, Priority: 1, Weight: 5}},
+	}
+	d := NewDNSDiscovery("example.com.", resolver, time.Hour)
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 2 && servers[0] == "tcp@a.example.com:8001", "expect lowest priority records only, got %v, err %v", servers, err)
+	items, err := d.Servers("")
+	_assert(err == nil && items[0].Meta[balancer.MetaWeight] == "3" && items[1].Meta[balancer.MetaWeight] == "", "unexpected weights %v", items)
+	items, err = d.Servers("billing.v2.Invoice.Create")
+	_assert(err == nil && len(items) == 1 && items[0].Addr == "tcp@c.example.com:9000", "unexpected service records %v, err %v", items, err)
+
+	// 结果在ttl内被缓存
+	resolver["_billing-v2-invoice._tcp.example.com"] = nil
+	items, _ = d.Servers("billing.v2.Invoice.Create")
+	_assert(len(items) == 1, "expect cached records, got %v", items)
+	_, err = d.Servers("Foo.Sum")
+	_assert(err != nil, "expect lookup error")
+
+	// GetAll与Get在ttl内只查询一次，过期后重新查询
+	counter := &countingResolver{Resolver: resolver}
+	d = NewDNSDiscovery("example.com", counter, 50*time.Millisecond)
+	for i := 0; i < 5; i++ {
+		_, _ = d.GetAll()
+		_, _ = d.Get(RoundRobinSelect)
+	}
+	_assert(atomic.LoadInt32(&counter.lookups) == 1, "expect cached lookups, got %d", counter.lookups)
+	time.Sleep(60 * time.Millisecond)
+	_, _ = d.GetAll()
+	_assert(atomic.LoadInt32(&counter.lookups) == 2, "expect a lookup after ttl, got %d", counter.lookups)
+
+	// 修改返回值不影响缓存
+	items, _ = d.Servers("")
+	items[0].Addr, items[0].Meta[balancer.MetaWeight] = "tcp@evil:1", "100"
+	items, _ = d.Servers("")
+	_assert(items[0].Addr == "tcp@a.example.com:8001" && items[0].Meta[balancer.MetaWeight] == "3", "expect cache untouched, got %v", items)
+
+	// 缓存过期时并发的查询只发出一次
+	slow := &countingResolver{Resolver: slowResolver{resolver, 50 * time.Millisecond}}
+	d = NewDNSDiscovery("example.com", slow, time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			items, err := d.Servers("")
+			_assert(err == nil && len(items) == 2, "unexpected concurrent result %v, err %v", items, err)
+		}()
+	}
+	wg.Wait()
+	_assert(atomic.LoadInt32(&slow.lookups) == 1, "expect concurrent lookups to be merged, got %d", slow.lookups)
+}
+
+type slowResolver struct {
+	Resolver
+	delay time.Duration
+}
+
+func (r slowResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	time.Sleep(r.delay)
+	return r.Resolver.LookupSRV(ctx, service, proto, name)
+}
+
+func TestFileDiscovery(t *testing.T) {
+	dir := t.TempDir()
+	t.Run("json", func(t *testing.T) {
+		path := filepath.Join(dir, "services.json")
+		_ = os.WriteFile(path, []byte(`{"Foo": ["tcp@a:1", "tcp@b:1"], "Foo.Slow": ["tcp@c:1"]}`), 0644)
+		d, err := NewFileDiscovery(path, 10*time.Millisecond)
+		_assert(err == nil, "load error: %v", err)
+		defer func() { _ = d.Close() }()
+		servers, _ := d.GetAll()
+		_assert(len(servers) == 3, "expect all addresses, got %v", servers)
+		items, _ := d.Servers("Foo.Sum")
+		_assert(len(items) == 2 && items[0].Addr == "tcp@a:1", "expect service level addresses, got %v", items)
+		items, _ = d.Servers("Foo.Slow")
+		_assert(len(items) == 1 && items[0].Addr == "tcp@c:1", "expect method level addresses, got %v", items)
+
+		_ = os.WriteFile(path, []byte(`{"Foo": ["tcp@d:1"]}`), 0644)
+		deadline := time.Now().Add(5 * time.Second)
+		for items, _ = d.Servers("Foo.Slow"); len(items) != 1 || items[0].Addr != "tcp@d:1"; items, _ = d.Servers("Foo.Slow") {
+			_assert(time.Now().Before(deadline), "expect reload on change, got %v", items)
+			time.Sleep(10 * time.Millisecond)
+		}
+		// 文件损坏时保留上一次的结果
+		_ = os.WriteFile(path, []byte(`{"Foo": [`), 0644)
+		time.Sleep(50 * time.Millisecond)
+		servers, _ = d.GetAll()
+		_assert(len(servers) == 1 && servers[0] == "tcp@d:1", "expect previous result kept, got %v", servers)
+	})
+	t.Run("yaml", func(t *testing.T) {
+		path := filepath.Join(dir, "services.yaml")
+		_ = os.WriteFile(path, []byte("# zrpc services\nFoo:\n  - tcp@a:1\n  - \"tcp@b:1\" # primary\nBar.Echo: [tcp@c:1, 'tcp@d:1']\nBaz: tcp@e:1\n"), 0644)
+		d, err := NewFileDiscovery(path, time.Hour)
+		_assert(err == nil, "load error: %v", err)
+		defer func() { _ = d.Close() }()
+		foo, _ := d.Servers("Foo.Sum")
+		echo, _ := d.Servers("Bar.Echo")
+		baz, _ := d.Servers("Baz.Ping")
+		_assert(len(foo) == 2 && foo[1].Addr == "tcp@b:1" && len(echo) == 2 && echo[1].Addr == "tcp@d:1" && len(baz) == 1, "unexpected yaml result %v %v %v", foo, echo, baz)
+		_, err = parseServicesYAML([]byte("  - tcp@a:1\n"))
+		_assert(err != nil, "expect error for list item without a service")
+	})
+}
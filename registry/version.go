@@ -0,0 +1,187 @@
+package registry
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version 语义化版本号 major.minor.patch[-pre]
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 string
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// Compare 返回-1、0、1，预发布版本小于对应的正式版本
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case o.Pre == "":
+		return -1
+	case v.Pre < o.Pre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// ParseVersion 解析完整的版本号，允许带 "v" 前缀
+func ParseVersion(s string) (Version, error) {
+	v, n, err := parsePartial(s)
+	if err != nil {
+		return Version{}, err
+	}
+	if n != 3 {
+		return Version{}, fmt.Errorf("rpc registry: incomplete version %q", s)
+	}
+	return v, nil
+}
+
+// 解析可能不完整的版本号（如 "1"、"1.2"、"1.x"），返回已给出的段数
+func parsePartial(s string) (Version, int, error) {
+	var v Version
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.Index(s, "-"); i >= 0 {
+		v.Pre, s = s[i+1:], s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || s == "" {
+		return v, 0, fmt.Errorf("rpc registry: invalid version %q", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	n := 0
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			break
+		}
+		num, err := strconv.Atoi(p)
+		if err != nil || num < 0 {
+			return v, 0, fmt.Errorf("rpc registry: invalid version %q", s)
+		}
+		*nums[i] = num
+		n++
+	}
+	return v, n, nil
+}
+
+type comparator struct {
+	op string
+	v  Version
+}
+
+func (c comparator) check(v Version) bool {
+	r := v.Compare(c.v)
+	switch c.op {
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	case "<":
+		return r < 0
+	case "<=":
+		return r <= 0
+	default:
+		return r == 0
+	}
+}
+
+// Constraint 版本约束，所有条件同时满足才算匹配。支持：
+//
+//	1.2.3 / =1.2.3      精确匹配
+//	1 / 1.x / 1.2       匹配给出的前缀
+//	^1.2.3              主版本相同且不低于1.2.3
+//	~1.2.3              次版本相同且不低于1.2.3
+//	>=1.2.0 <2.0.0      以空格分隔的范围
+type Constraint struct {
+	raw  string
+	cmps []comparator
+}
+
+func (c *Constraint) String() string { return c.raw }
+
+// Check 判断版本是否满足约束，无法解析的版本视为不满足
+func (c *Constraint) Check(version string) bool {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return false
+	}
+	for _, cmp := range c.cmps {
+		if !cmp.check(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{raw: s}
+	for _, term := range strings.Fields(s) {
+		op := ""
+		for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+			if strings.HasPrefix(term, prefix) {
+				op, term = prefix, term[len(prefix):]
+				break
+			}
+		}
+		v, n, err := parsePartial(term)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			if op != "" {
+				return nil, fmt.Errorf("rpc registry: invalid version constraint %q", s)
+			}
+			continue // "*" 或 "x"
+		}
+		c.cmps = append(c.cmps, expand(op, v, n)...)
+	}
+	if len(c.cmps) == 0 && strings.TrimSpace(s) != "*" {
+		return nil, fmt.Errorf("rpc registry: empty version constraint %q", s)
+	}
+	return c, nil
+}
+
+// 将各种写法展开为比较条件
+func expand(op string, v Version, n int) []comparator {
+	switch op {
+	case "^":
+		upper := Version{Major: v.Major + 1}
+		if v.Major == 0 && n > 1 {
+			upper = Version{Minor: v.Minor + 1}
+		}
+		return []comparator{{">=", v}, {"<", upper}}
+	case "~":
+		if n == 1 {
+			return []comparator{{">=", v}, {"<", Version{Major: v.Major + 1}}}
+		}
+		return []comparator{{">=", v}, {"<", Version{Major: v.Major, Minor: v.Minor + 1}}}
+	case "", "=":
+		switch n {
+		case 1:
+			return []comparator{{">=", v}, {"<", Version{Major: v.Major + 1}}}
+		case 2:
+			return []comparator{{">=", v}, {"<", Version{Major: v.Major, Minor: v.Minor + 1}}}
+		}
+		return []comparator{{"=", v}}
+	}
+	return []comparator{{op, v}}
+}
@@ -0,0 +1,48 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed:"+msg, v...))
+	}
+}
+
+func TestConstraint_Check(t *testing.T) {
+	cases := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"=1.2.3", "1.2.4", false},
+		{"1", "1.9.0", true},
+		{"1.x", "2.0.0", false},
+		{"1.2", "1.2.9", true},
+		{"1.2", "1.3.0", false},
+		{"^1.2.0", "1.9.9", true},
+		{"^1.2.0", "1.1.0", false},
+		{"^0.2.1", "0.3.0", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{">=1.2.0 <2.0.0", "1.5.0", true},
+		{">=1.2.0 <2.0.0", "2.0.0", false},
+		{">1.0.0", "1.0.1-beta", true},
+		{"<1.0.0", "1.0.0-beta", true},
+		{"*", "0.0.1", true},
+		{"1", "", false},
+		{"v1", "v1.2.3", true},
+	}
+	for _, c := range cases {
+		cons, err := ParseConstraint(c.constraint)
+		_assert(err == nil, "parse %q: %v", c.constraint, err)
+		_assert(cons.Check(c.version) == c.want, "%q check %q, expect %v", c.constraint, c.version, c.want)
+	}
+	for _, bad := range []string{"", "a.b", "1.2.3.4", ">=x.y"} {
+		_, err := ParseConstraint(bad)
+		_assert(err != nil, "expect error for %q", bad)
+	}
+}
@@ -1,17 +1,19 @@
 package registry
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 )
 
 type ZRegistryDiscovery struct {
 	*MultiServersDiscovery
 	registryAddr string
+	endpoints    *Endpoints
 	timeout      time.Duration
 	lastUpdate   time.Time
+	meta         map[string]map[string]string
 }
 
 const defaultUpdateTimeout = time.Second * 10
@@ -23,6 +25,7 @@ func NewZRegistryDiscovery(registerAddr string, timeout time.Duration) *ZRegistr
 	d := &ZRegistryDiscovery{
 		MultiServersDiscovery: NewMultiServersDiscovery(make([]string, 0)),
 		registryAddr:          registerAddr,
+		endpoints:             NewEndpoints(registerAddr),
 		timeout:               timeout,
 	}
 	return d
@@ -43,22 +46,40 @@ func (d *ZRegistryDiscovery) Refresh() error {
 		return nil
 	}
 	log.Println("rpc registry: refresh servers from registry ", d.registryAddr)
-	resp, err := http.Get(d.registryAddr)
+	resp, err := d.endpoints.Do(func(addr string) (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, addr, nil)
+	})
 	if err != nil {
 		log.Println("rpc registry refresh err:", err)
 		return err
 	}
-	servers := strings.Split(resp.Header.Get("X-Zrpc-Servers"), ",")
-	d.servers = make([]string, 0, len(servers))
-	for _, server := range servers {
-		if strings.TrimSpace(server) != "" {
-			d.servers = append(d.servers, strings.TrimSpace(server))
-		}
+	defer func() { _ = resp.Body.Close() }()
+	// 响应体中包含所有存活的服务器及其元数据
+	var items []ServerItem
+	if err = json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		log.Println("rpc registry refresh err:", err)
+		return err
+	}
+	d.servers = make([]string, 0, len(items))
+	d.meta = make(map[string]map[string]string, len(items))
+	for _, item := range items {
+		d.servers = append(d.servers, item.Addr)
+		d.meta[item.Addr] = item.Meta
 	}
 	d.lastUpdate = time.Now()
 	return nil
 }
 
+// Meta 返回服务器上报的元数据
+func (d *ZRegistryDiscovery) Meta(addr string) map[string]string {
+	if err := d.Refresh(); err != nil {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.meta[addr]
+}
+
 func (d *ZRegistryDiscovery) Get(mode SelectMode) (string, error) {
 	if err := d.Refresh(); err != nil {
 		return "", err
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"zrpc/registry"
+)
+
+// 健康状态
+const (
+	Serving    = "SERVING"
+	NotServing = "NOT_SERVING"
+	Draining   = "DRAINING" // 正在下线：不再接收新流量，已建立的调用仍会处理
+)
+
+const (
+	// HealthService 每个Server自动注册的健康检查服务，提供 Health.Check(service string, status *string)
+	HealthService     = "Health"
+	DefaultHealthPath = "/debug/zrpc/health"
+)
+
+func (server *Server) registerHealth() {
+	_ = RegisterFunc(server, registry.HealthCheckMethod, func(service string, status *string) error {
+		var err error
+		*status, err = server.ServingStatus(service)
+		return err
+	})
+}
+
+// SetServingStatus 设置服务的健康状态，service为空时设置整个服务器。
+// 非SERVING的服务不再随心跳上报，注册中心立即停止向其路由
+func (server *Server) SetServingStatus(service, status string) error {
+	switch status {
+	case Serving, NotServing, Draining:
+	default:
+		return errors.New("rpc server: invalid serving status " + status)
+	}
+	server.mu.Lock()
+	if _, ok := server.serviceMap.Load(service); service != "" && !ok {
+		server.mu.Unlock()
+		return errors.New("rpc server: health: unknown service " + service)
+	}
+	server.health[service] = status
+	server.mu.Unlock()
+	if server.announced() {
+		go func() { _ = server.sendHeartbeat() }()
+	}
+	return nil
+}
+
+// ServingStatus 返回服务的健康状态，service为空时返回整个服务器的状态；
+// 整个服务器不处于SERVING时各服务的状态与之相同
+func (server *Server) ServingStatus(service string) (string, error) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.servingStatus(service)
+}
+
+// 调用方需持有server.mu
+func (server *Server) servingStatus(service string) (string, error) {
+	if status, ok := server.health[""]; ok && status != Serving {
+		return status, nil
+	}
+	if service == "" {
+		return Serving, nil
+	}
+	if _, ok := server.serviceMap.Load(service); !ok {
+		return "", errors.New("rpc server: health: unknown service " + service)
+	}
+	if status, ok := server.health[service]; ok {
+		return status, nil
+	}
+	return Serving, nil
+}
+
+// ServeHealth 供七层负载均衡器与Kubernetes探针使用：GET DefaultHealthPath?service=Foo，
+// SERVING时返回200，否则返回503，响应体为状态
+func (server *Server) ServeHealth(w http.ResponseWriter, req *http.Request) {
+	status, err := server.ServingStatus(req.URL.Query().Get("service"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if status != Serving {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = fmt.Fprintln(w, status)
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"zrpc/registry"
+)
+
+func TestServer_Health(t *testing.T) {
+	r := registry.New(registry.DefaultTimeout)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	server := NewServer(ts.URL, "tcp@127.0.0.1:2")
+	_ = server.Register(new(Foo))
+
+	check := func(service string) (string, error) {
+		reply, err := server.Invoke(registry.HealthCheckMethod, func(argv interface{}) error {
+			*argv.(*string) = service
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		return *reply.(*string), nil
+	}
+	probe := func(service string) (int, string) {
+		w := httptest.NewRecorder()
+		server.ServeHealth(w, httptest.NewRequest(http.MethodGet, DefaultHealthPath+"?service="+service, nil))
+		return w.Code, strings.TrimSpace(w.Body.String())
+	}
+	discover := func() string {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("X-Zrpc-Services", "Foo.Sum")
+		req.Header.Set("X-Zrpc-Mode", "RoundRobin")
+		resp, _ := http.DefaultClient.Do(req)
+		_ = resp.Body.Close()
+		return resp.Header.Get("X-Zrpc-Servers")
+	}
+
+	status, err := check("")
+	_assert(err == nil && status == Serving, "expect SERVING by default, got %s, err %v", status, err)
+	_, err = check("Nope")
+	_assert(err != nil, "expect error for unknown service")
+	_ = server.sendHeartbeat()
+	_assert(discover() == "tcp@127.0.0.1:2", "expect serving service to be discoverable")
+
+	_ = server.SetServingStatus("Foo", NotServing)
+	status, _ = check("Foo")
+	_assert(status == NotServing, "expect per-service status, got %s", status)
+	code, body := probe("Foo")
+	_assert(code == http.StatusServiceUnavailable && body == NotServing, "expect 503, got %d %s", code, body)
+	_ = server.sendHeartbeat()
+	_assert(discover() == "", "expect registry to stop routing to a NOT_SERVING service")
+
+	_ = server.SetServingStatus("Foo", Serving)
+	_ = server.SetServingStatus("", Draining)
+	status, _ = check("Foo")
+	_assert(status == Draining, "expect overall status to override services, got %s", status)
+	code, _ = probe("")
+	_assert(code == http.StatusServiceUnavailable, "expect draining server to fail probes")
+	_assert(server.SetServingStatus("", "UNKNOWN") != nil, "expect invalid status error")
+
+	_ = server.SetServingStatus("", Serving)
+	code, body = probe("")
+	_assert(code == http.StatusOK && body == Serving, "expect 200, got %d %s", code, body)
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// JSON-RPC 2.0 标准错误码
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+	JSONRPCServerError    = -32000 // 方法本身返回的错误
+)
+
+type jsonrpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"` // 缺少id成员时为nil，表示通知，无需应答；"id": null仍需应答
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var jsonrpcNullID = json.RawMessage("null")
+
+func newJSONRPCError(id json.RawMessage, code int, msg string) *jsonrpcResponse {
+	return &jsonrpcResponse{Version: "2.0", Error: &jsonrpcError{Code: code, Message: msg}, ID: id}
+}
+
+// ServeJSONRPC 以HTTP POST接收JSON-RPC 2.0请求，method为 "Service.Method"
+func (server *Server) ServeJSONRPC(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = io.WriteString(w, "405 must POST\n")
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	resp := server.handleJSONRPC(body)
+	if resp == nil { // 全部为通知
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		log.Println("rpc server: jsonrpc write response error:", err)
+	}
+}
+
+// ListenJSONRPC 在listener上以原始TCP提供JSON-RPC 2.0服务
+func (server *Server) ListenJSONRPC(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Println("rpc server: jsonrpc accept error:", err)
+			return
+		}
+		go server.ServeJSONRPCConn(conn)
+	}
+}
+
+// ServeJSONRPCConn 在原始连接上处理以换行或空白分隔的JSON-RPC 2.0消息
+func (server *Server) ServeJSONRPCConn(conn io.ReadWriteCloser) {
+	defer func() { _ = conn.Close() }()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	sending := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+	for {
+		var msg json.RawMessage
+		if err := dec.Decode(&msg); err != nil {
+			if err != io.EOF {
+				// 流已无法继续解析，回复解析错误后断开
+				sending.Lock()
+				_ = enc.Encode(newJSONRPCError(jsonrpcNullID, JSONRPCParseError, "parse error"))
+				sending.Unlock()
+			}
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := server.handleJSONRPC(msg)
+			if resp == nil {
+				return
+			}
+			sending.Lock()
+			defer sending.Unlock()
+			if err := enc.Encode(resp); err != nil {
+				log.Println("rpc server: jsonrpc write response error:", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// 处理单个请求或批量请求，返回nil表示无需应答
+func (server *Server) handleJSONRPC(msg []byte) interface{} {
+	msg = bytes.TrimSpace(msg)
+	if len(msg) > 0 && msg[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(msg, &batch); err != nil {
+			return newJSONRPCError(jsonrpcNullID, JSONRPCParseError, "parse error")
+		}
+		if len(batch) == 0 {
+			return newJSONRPCError(jsonrpcNullID, JSONRPCInvalidRequest, "invalid request: empty batch")
+		}
+		resps := make([]*jsonrpcResponse, 0, len(batch))
+		for _, item := range batch {
+			if resp := server.handleJSONRPCRequest(item); resp != nil {
+				resps = append(resps, resp)
+			}
+		}
+		if len(resps) == 0 {
+			return nil
+		}
+		return resps
+	}
+	if !json.Valid(msg) {
+		return newJSONRPCError(jsonrpcNullID, JSONRPCParseError, "parse error")
+	}
+	if resp := server.handleJSONRPCRequest(msg); resp != nil {
+		return resp
+	}
+	return nil
+}
+
+func (server *Server) handleJSONRPCRequest(msg json.RawMessage) *jsonrpcResponse {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(msg, &req); err != nil || req.Version != "2.0" || req.Method == "" {
+		return newJSONRPCError(requestID(msg), JSONRPCInvalidRequest, "invalid request")
+	}
+	resp := server.callJSONRPC(&req, req.ID)
+	if req.ID == nil {
+		return nil
+	}
+	return resp
+}
+
+// 无效请求中能识别出id（字符串或数字）时在错误应答中带回，否则为null
+func requestID(msg json.RawMessage) json.RawMessage {
+	var req struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(msg, &req); err != nil || len(req.ID) == 0 {
+		return jsonrpcNullID
+	}
+	if c := req.ID[0]; c == '"' || c == '-' || (c >= '0' && c <= '9') {
+		return req.ID
+	}
+	return jsonrpcNullID
+}
+
+func (server *Server) callJSONRPC(req *jsonrpcRequest, id json.RawMessage) (resp *jsonrpcResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("rpc server: jsonrpc panic:", r)
+			resp = newJSONRPCError(id, JSONRPCInternalError, "internal error")
+		}
+	}()
+	svc, mtype, err := server.findService(req.Method)
+	if err != nil {
+		return newJSONRPCError(id, JSONRPCMethodNotFound, err.Error())
+	}
+	argv, replyv := mtype.newArgv(), mtype.newRpleyv()
+	argvi := argv.Interface()
+	if argv.Type().Kind() != reflect.Ptr {
+		argvi = argv.Addr().Interface()
+	}
+	if err = decodeJSONRPCParams(req.Params, mtype.ArgType, argvi); err != nil {
+		return newJSONRPCError(id, JSONRPCInvalidParams, "invalid params: "+err.Error())
+	}
+	if err = svc.call(mtype, argv, replyv); err != nil {
+		return newJSONRPCError(id, JSONRPCServerError, err.Error())
+	}
+	return &jsonrpcResponse{Version: "2.0", Result: replyv.Interface(), ID: id}
+}
+
+// params可以是参数本身，也可以是只含一个参数的数组
+func decodeJSONRPCParams(params json.RawMessage, argType reflect.Type, argvi interface{}) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 {
+		return nil
+	}
+	if argType.Kind() == reflect.Ptr {
+		argType = argType.Elem()
+	}
+	if params[0] == '[' && argType.Kind() != reflect.Slice && argType.Kind() != reflect.Array {
+		var positional []json.RawMessage
+		if err := json.Unmarshal(params, &positional); err != nil {
+			return err
+		}
+		if len(positional) != 1 {
+			return errors.New("expect exactly one positional param")
+		}
+		params = positional[0]
+	}
+	return json.Unmarshal(params, argvi)
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"zrpc/transport"
+)
+
+func postJSONRPC(url, body string) (int, string) {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	_assert(err == nil, "post error: %v", err)
+	defer func() { _ = resp.Body.Close() }()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(b))
+}
+
+func TestServer_ServeJSONRPC(t *testing.T) {
+	server := NewServer("", "")
+	_ = server.Register(new(Foo))
+	ts := httptest.NewServer(http.HandlerFunc(server.ServeJSONRPC))
+	defer ts.Close()
+
+	t.Run("call", func(t *testing.T) {
+		_, body := postJSONRPC(ts.URL, `{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":1}`)
+		_assert(body == `{"jsonrpc":"2.0","result":3,"id":1}`, "unexpected response %s", body)
+		_, body = postJSONRPC(ts.URL, `{"jsonrpc":"2.0","method":"Foo.Sum","params":[{"Num1":3,"Num2":4}],"id":"a"}`)
+		_assert(body == `{"jsonrpc":"2.0","result":7,"id":"a"}`, "unexpected response %s", body)
+	})
+	t.Run("errors", func(t *testing.T) {
+		var resp jsonrpcResponse
+		_, body := postJSONRPC(ts.URL, `{"jsonrpc":"2.0","method":"Foo.Mul","id":1}`)
+		_ = json.Unmarshal([]byte(body), &resp)
+		_assert(resp.Error != nil && resp.Error.Code == JSONRPCMethodNotFound, "expect method not found, got %s", body)
+		_, body = postJSONRPC(ts.URL, `{"jsonrpc":"2.0","method":"Foo.Sum","params":[1,2],"id":1}`)
+		_ = json.Unmarshal([]byte(body), &resp)
+		_assert(resp.Error != nil && resp.Error.Code == JSONRPCInvalidParams, "expect invalid params, got %s", body)
+		// 请求无效但id可识别时，错误应答带回该id
+		for _, req := range []string{`{"jsonrpc":"2.0","id":5}`, `{"jsonrpc":"1.0","method":"Foo.Sum","id":5}`, `{"jsonrpc":"2.0","method":1,"id":5}`} {
+			_, body = postJSONRPC(ts.URL, req)
+			_assert(body == `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":5}`, "expect invalid request with id 5 for %s, got %s", req, body)
+		}
+		_, body = postJSONRPC(ts.URL, `{"jsonrpc":"2.0","id":{"a":1}}`)
+		_assert(strings.HasSuffix(body, `"id":null}`), "expect null id for invalid id, got %s", body)
+		_, body = postJSONRPC(ts.URL, `{"jsonrpc":`)
+		_ = json.Unmarshal([]byte(body), &resp)
+		_assert(resp.Error != nil && resp.Error.Code == JSONRPCParseError, "expect parse error, got %s", body)
+	})
+	t.Run("batch and notification", func(t *testing.T) {
+		code, body := postJSONRPC(ts.URL, `{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1}}`)
+		_assert(code == http.StatusNoContent && body == "", "notification expects no content, got %d %s", code, body)
+		// 只有缺少id成员才是通知，"id": null仍需应答
+		code, body = postJSONRPC(ts.URL, `{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":1},"id":null}`)
+		_assert(code == http.StatusOK && body == `{"jsonrpc":"2.0","result":2,"id":null}`, "expect a response for null id, got %d %s", code, body)
+		_, body = postJSONRPC(ts.URL, `[
+			{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":1},"id":1},
+			{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":1}},
+			{"foo":"bar"}
+		]`)
+		var resps []jsonrpcResponse
+		_ = json.Unmarshal([]byte(body), &resps)
+		_assert(len(resps) == 2 && resps[1].Error.Code == JSONRPCInvalidRequest, "unexpected batch response %s", body)
+	})
+}
+
+func TestServer_ServeJSONRPCConn(t *testing.T) {
+	server := NewServer("", "")
+	_ = server.Register(new(Foo))
+	c, s := transport.Pipe(nil)
+	go server.ServeJSONRPCConn(s)
+	defer func() { _ = c.Close() }()
+
+	_, _ = io.WriteString(c, `{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":5,"Num2":6},"id":9}`+"\n")
+	line, err := bufio.NewReader(c).ReadString('\n')
+	_assert(err == nil && strings.TrimSpace(line) == `{"jsonrpc":"2.0","result":11,"id":9}`, "unexpected response %s", line)
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"zrpc/codec"
+)
+
+// 与net/rpc的HTTP模式保持一致
+const (
+	netRPCConnected       = "200 Connected to Go RPC"
+	DefaultNetRPCHTTPPath = "/_goRPC_"
+)
+
+// ServeNetRPCConn 以net/rpc的协议处理连接，跳过zrpc的option握手，可直接服务rpc.Dial建立的客户端
+func (server *Server) ServeNetRPCConn(conn io.ReadWriteCloser) {
+	server.serveCodec(codec.NewNetRPCCodec(conn), 0)
+}
+
+// ListenNetRPC 在listener上以net/rpc协议提供服务，便于逐步迁移
+func (server *Server) ListenNetRPC(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Println("rpc server: net/rpc accept error:", err)
+			return
+		}
+		go server.ServeNetRPCConn(conn)
+	}
+}
+
+// ServeNetRPCHTTP 兼容rpc.DialHTTP，使用CONNECT建立连接
+func (server *Server) ServeNetRPCHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodConnect {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		log.Print("rpc hijacking ", req.RemoteAddr, ": ", err.Error())
+		return
+	}
+	_, _ = io.WriteString(conn, "HTTP/1.0 "+netRPCConnected+"\n\n")
+	server.ServeNetRPCConn(conn)
+}
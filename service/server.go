@@ -1,6 +1,7 @@
 package service
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,12 +9,14 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strings"
 	"sync"
 	"time"
 	"zrpc/codec"
 	"zrpc/registry"
+	"zrpc/transport"
 )
 
 const MagicNumber = 0x3bef5c
@@ -34,25 +37,66 @@ var DefaultOption = &Option{
 
 // 表示服务器
 type Server struct {
+	mu           sync.Mutex // 保证服务注册的原子性
 	registerAddr string
+	endpoints    *registry.Endpoints // 注册中心，多个地址时自动切换
 	addr         string
 	serviceMap   sync.Map
 	methodMap    sync.Map
+	meta         map[string]string // 随心跳上报的元数据
+	health       map[string]string // 服务名 -> 健康状态，""表示整个服务器
+	gossip       *registry.Gossip  // 加入gossip集群时非nil，注册信息随心跳公布给其他成员
+	stop         chan struct{}     // 关闭后停止心跳
 }
 
 func NewServer(registerAddr, serverAddr string) *Server {
-	return &Server{
+	server := &Server{
 		registerAddr: registerAddr,
+		endpoints:    registry.NewEndpoints(registerAddr),
 		addr:         serverAddr,
 		serviceMap:   sync.Map{},
 		methodMap:    sync.Map{},
+		meta:         map[string]string{},
+		health:       map[string]string{},
+		stop:         make(chan struct{}),
 	}
+	server.registerHealth()
+	return server
+}
+
+// SetMetadata 设置随心跳上报给注册中心的元数据，如 weight、zone、region 或自定义标签，
+// value为空时删除该项
+func (server *Server) SetMetadata(key, value string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if value == "" {
+		delete(server.meta, key)
+		return
+	}
+	server.meta[key] = value
 }
 
 //var DefaultServer = NewServer()
 
 func (server *Server) Register(rcvr interface{}) error {
-	s := newService(rcvr)
+	return server.register("", rcvr)
+}
+
+// RegisterName 以指定的名字注册服务，名字中可以带"."，如 "billing.v2.Invoice"
+func (server *Server) RegisterName(name string, rcvr interface{}) error {
+	if name == "" {
+		return errors.New("rpc server: service name is empty")
+	}
+	return server.register(name, rcvr)
+}
+
+func (server *Server) register(name string, rcvr interface{}) error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	s, err := newService(name, rcvr)
+	if err != nil {
+		return err
+	}
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
 		return fmt.Errorf("rpc: service already defined:" + s.name)
 	}
@@ -62,10 +106,90 @@ func (server *Server) Register(rcvr interface{}) error {
 	return nil
 }
 
+// SetVersion 设置服务的语义化版本号，客户端可按版本约束选择服务器
+func (server *Server) SetVersion(name, version string) error {
+	if _, err := registry.ParseVersion(version); err != nil {
+		return err
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	svci, ok := server.serviceMap.Load(name)
+	if !ok {
+		return &NotFoundError{Kind: "service", Name: name}
+	}
+	svci.(*service).version = version
+	return nil
+}
+
+// Unregister 移除服务，并立即向注册中心发送心跳，使其不再将该服务路由到本机
+func (server *Server) Unregister(name string) error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if _, ok := server.serviceMap.LoadAndDelete(name); !ok {
+		return &NotFoundError{Kind: "service", Name: name}
+	}
+	server.methodMap.Range(func(key, _ interface{}) bool {
+		serviceMethod := key.(string)
+		if serviceMethod[:strings.LastIndex(serviceMethod, ".")] == name {
+			server.methodMap.Delete(key)
+		}
+		return true
+	})
+	if server.registerAddr != "" || server.gossip != nil {
+		go func() { _ = server.sendHeartbeat() }()
+	}
+	return nil
+}
+
+// JoinGossip 通过gossip集群公布本服务器的服务，无需中心化的注册中心，
+// 客户端以registry.NewGossipDiscovery加入同一集群即可发现
+func (server *Server) JoinGossip(g *registry.Gossip) {
+	server.mu.Lock()
+	server.gossip = g
+	server.mu.Unlock()
+	_ = server.sendHeartbeat()
+}
+
+// 是否配置了注册中心或gossip集群
+func (server *Server) announced() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.registerAddr != "" || server.gossip != nil
+}
+
 //func Register(rcvr interface{}) error {
 //	return DefaultServer.Register(rcvr)
 //}
 
+// ErrNotFound 请求的服务或方法未注册，以errors.Is(err, ErrNotFound)判断
+var ErrNotFound = errors.New("rpc server: service or method not found")
+
+const notFoundPrefix = "rpc server: can't find "
+
+// NotFoundError 请求的服务或方法未注册。错误以文本形式传回客户端，客户端经ParseError还原后同样可以判断
+type NotFoundError struct {
+	Kind string // "service" 或 "method"
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return notFoundPrefix + e.Kind + ":" + e.Name
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// ParseError 将响应头中的错误信息还原为error，服务或方法未注册时返回*NotFoundError
+func ParseError(msg string) error {
+	if rest := strings.TrimPrefix(msg, notFoundPrefix); rest != msg {
+		if kind, name, ok := strings.Cut(rest, ":"); ok && (kind == "service" || kind == "method") {
+			return &NotFoundError{Kind: kind, Name: name}
+		}
+	}
+	return errors.New(msg)
+}
+
 func (server *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
@@ -75,17 +199,37 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 	svci, ok := server.serviceMap.Load(serviceName)
 	if !ok {
-		err = errors.New("rpc server: can't find service:" + serviceName)
+		err = &NotFoundError{Kind: "service", Name: serviceName}
 		return
 	}
 	svc = svci.(*service)
 	mtype = svc.methods[methodName]
 	if mtype == nil {
-		err = errors.New("rpc server: can't find method:" + methodName)
+		err = &NotFoundError{Kind: "method", Name: methodName}
 	}
 	return
 }
 
+// Invoke 在进程内直接调用已注册的方法，decode负责将请求数据解码到参数指针上，返回值为应答指针
+func (server *Server) Invoke(serviceMethod string, decode func(argv interface{}) error) (interface{}, error) {
+	svc, mtype, err := server.findService(serviceMethod)
+	if err != nil {
+		return nil, err
+	}
+	argv, replyv := mtype.newArgv(), mtype.newRpleyv()
+	argvi := argv.Interface()
+	if argv.Type().Kind() != reflect.Ptr {
+		argvi = argv.Addr().Interface()
+	}
+	if err = decode(argvi); err != nil {
+		return nil, err
+	}
+	if err = svc.call(mtype, argv, replyv); err != nil {
+		return nil, err
+	}
+	return replyv.Interface(), nil
+}
+
 // server注册传入的端口/listener
 func (server *Server) Listen(listener net.Listener, heartbeatPeriod time.Duration) {
 	go server.Heartbeat(heartbeatPeriod)
@@ -105,9 +249,11 @@ func (server *Server) Listen(listener net.Listener, heartbeatPeriod time.Duratio
 //}
 
 const (
-	connected        = "200 Connected to Gee RPC"
-	DefaultRPCPath   = "/zrpc"
-	DefaultDebugPath = "/debug/zrpc"
+	connected          = "200 Connected to Gee RPC"
+	DefaultRPCPath     = "/zrpc"
+	DefaultWSPath      = "/zrpc/ws"
+	DefaultJSONRPCPath = "/zrpc/jsonrpc"
+	DefaultDebugPath   = "/debug/zrpc"
 )
 
 func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -125,9 +271,22 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	server.ServeConn(conn)
 }
 
+// ServeWebSocket 通过WebSocket承载与ServeConn相同的协议，便于穿过浏览器与七层代理
+func (server *Server) ServeWebSocket(w http.ResponseWriter, req *http.Request) {
+	conn, err := transport.AcceptWebSocket(w, req)
+	if err != nil {
+		log.Print("rpc websocket upgrade ", req.RemoteAddr, ": ", err.Error())
+		return
+	}
+	server.ServeConn(conn)
+}
+
 func (server *Server) RegisterHTTPInterface() {
 	http.Handle(DefaultRPCPath, server)
+	http.HandleFunc(DefaultWSPath, server.ServeWebSocket)
+	http.HandleFunc(DefaultJSONRPCPath, server.ServeJSONRPC)
 	http.Handle(DefaultDebugPath, debugHTTP{server})
+	http.HandleFunc(DefaultHealthPath, server.ServeHealth)
 }
 
 //func RegisterHTTPInterface() {
@@ -137,10 +296,15 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 	defer func() { _ = conn.Close() }() // 关闭连接
 	var opt Option
 	// 从连接中解析opt，确定此次rpc通信的协议选项
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
 		log.Println("rpc server: options error:", err)
 		return
 	}
+	// json解码器可能已多读入了后续的请求数据，去掉option末尾的换行后交还给编解码器
+	buffered, _ := io.ReadAll(dec.Buffered())
+	buffered = bytes.TrimLeft(buffered, " \t\r\n")
+	conn = &bufferedConn{Reader: io.MultiReader(bytes.NewReader(buffered), conn), ReadWriteCloser: conn}
 	if opt.MagicNumber != MagicNumber {
 		log.Printf("rpc server: invalid magic number: %x\n", opt.MagicNumber)
 		return
@@ -149,10 +313,20 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 	f := codec.NewCodecFuncMap[opt.CodecType]
 	if f == nil {
 		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
+		return
 	}
 	server.serveCodec(f(conn), opt.MaxCallTime) // 服务器正式与客户端开始沟通
 }
 
+type bufferedConn struct {
+	io.Reader
+	io.ReadWriteCloser
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.Reader.Read(p)
+}
+
 var invalidRequest = struct{}{}
 
 // 使用选定的编解码器，正式与客户端开始沟通
@@ -205,6 +379,10 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	req := &request{h: h}
 	// TypeOf返回的是Type类型，reflect.New返回一个指向某类型的零值的指针
 	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
+	if err != nil {
+		_ = cc.ReadBody(nil) // 丢弃无法处理的请求体
+		return req, err
+	}
 	req.argv, req.replyv = req.mtype.newArgv(), req.mtype.newRpleyv()
 	argvi := req.argv.Interface()
 	if req.argv.Type().Kind() != reflect.Ptr {
@@ -257,35 +435,94 @@ func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.
 	}
 }
 
+// Heartbeat 立即发送一次心跳，之后每隔period发送一次直到Close。
+// 发送失败（如所有注册中心暂时不可用）时只记录日志，下一个周期继续重试
 func (s *Server) Heartbeat(period time.Duration) {
+	if !s.announced() { // 未配置注册中心（如进程内服务），无需心跳
+		return
+	}
 	if period == 0 {
 		period = registry.DefaultTimeout - time.Minute
 	}
-	var err error
-	err = s.sendHeartbeat()
+	_ = s.sendHeartbeat()
 	go func() {
 		t := time.NewTicker(period)
-		for err == nil {
-			<-t.C
-			err = s.sendHeartbeat()
+		defer t.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+			}
+			_ = s.sendHeartbeat()
 		}
 	}()
 }
 
+// Close 停止心跳，注册中心将在超时后移除本服务器；监听器与已建立的连接由调用方关闭
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	return nil
+}
+
 func (s *Server) sendHeartbeat() error {
-	log.Println(s.addr, "send heart beat to registry ", s.registerAddr)
-	httpClient := &http.Client{}
-	req, _ := http.NewRequest(http.MethodPost, s.registerAddr, nil)
+	s.mu.Lock()
 	services := make([]string, 0)
 	s.methodMap.Range(func(key, value interface{}) bool {
-		services = append(services, key.(string))
+		method := key.(string)
+		svc := method[:strings.LastIndex(method, ".")]
+		// 非SERVING的服务不上报，健康检查服务始终上报
+		if status, _ := s.servingStatus(svc); status == Serving || svc == HealthService {
+			services = append(services, method)
+		}
 		return true
 	})
-	req.Header.Set("X-Zrpc-Servers", s.addr)
-	req.Header.Set("X-Zrpc-Services", strings.Join(services, ","))
-	if _, err := httpClient.Do(req); err != nil {
+	versions := make([]string, 0)
+	versionMap := map[string]string{}
+	s.serviceMap.Range(func(key, value interface{}) bool {
+		if v := value.(*service).version; v != "" {
+			versions = append(versions, key.(string)+"="+v)
+			versionMap[key.(string)] = v
+		}
+		return true
+	})
+	meta := url.Values{}
+	metaMap := make(map[string]string, len(s.meta))
+	for k, v := range s.meta {
+		meta.Set(k, v)
+		metaMap[k] = v
+	}
+	g := s.gossip
+	s.mu.Unlock()
+	if g != nil {
+		g.Advertise(s.addr, services, versionMap, metaMap)
+	}
+	if s.registerAddr == "" {
+		return nil
+	}
+	log.Println(s.addr, "send heart beat to registry ", s.registerAddr)
+	// 集群中的注册中心会相互转发心跳，发送给任一可用节点即可
+	resp, err := s.endpoints.Do(func(addr string) (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, addr, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Zrpc-Servers", s.addr)
+		req.Header.Set("X-Zrpc-Meta", meta.Encode())
+		req.Header.Set("X-Zrpc-Services", strings.Join(services, ","))
+		req.Header.Set("X-Zrpc-Versions", strings.Join(versions, ","))
+		return req, nil
+	})
+	if err != nil {
 		log.Println("rpc server: heart beat err:", err)
 		return err
 	}
+	_ = resp.Body.Close()
 	return nil
 }
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
@@ -12,6 +13,7 @@ type methodType struct {
 	ArgType   reflect.Type
 	ReplyType reflect.Type
 	numCalls  uint64
+	fn        func(argv, replyv interface{}) error // 通过RegisterFunc注册的方法，直接调用而非反射
 }
 
 func (m *methodType) NumCalls() uint64 {
@@ -43,21 +45,29 @@ func (m *methodType) newRpleyv() reflect.Value {
 
 type service struct {
 	name    string
+	version string // 语义化版本号，随心跳上报给注册中心
 	typ     reflect.Type
 	rcvr    reflect.Value
 	methods map[string]*methodType
 }
 
-func newService(rcvr interface{}) *service {
+// name为空时使用接收者的类型名作为服务名
+func newService(name string, rcvr interface{}) (*service, error) {
 	s := new(service)
 	s.rcvr = reflect.ValueOf(rcvr)
 	s.typ = reflect.TypeOf(rcvr)
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
-	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server: %s is not a valid service", s.name)
+	if name == "" {
+		name = reflect.Indirect(s.rcvr).Type().Name()
+		if !ast.IsExported(name) {
+			return nil, fmt.Errorf("rpc server: %s is not a valid service", name)
+		}
 	}
+	s.name = name
 	s.registerMethods()
-	return s
+	if len(s.methods) == 0 {
+		return nil, fmt.Errorf("rpc server: %s has no suitable methods", s.name)
+	}
+	return s, nil
 }
 
 func (s *service) registerMethods() {
@@ -93,6 +103,9 @@ func isExportedOrBuiltinType(typ reflect.Type) bool {
 
 func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.numCalls, 1)
+	if m.fn != nil {
+		return m.fn(argv.Interface(), replyv.Interface())
+	}
 	f := m.method.Func
 	if err := f.Call([]reflect.Value{s.rcvr, argv, replyv})[0].Interface(); err != nil {
 		return err.(error)
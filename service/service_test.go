@@ -2,8 +2,13 @@ package service
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
+	"zrpc/registry"
 )
 
 type Foo int
@@ -30,13 +35,13 @@ func _assert(condition bool, msg string, v ...interface{}) {
 
 func TestNewService(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, _ := newService("", &foo)
 	_assert(len(s.methods) == 1, "wrong service Method, expect=1, actual=%d", len(s.methods))
 }
 
 func TestMethodType_Call(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, _ := newService("", &foo)
 	mType := s.methods["Sum"]
 
 	argv := mType.newArgv()
@@ -45,3 +50,100 @@ func TestMethodType_Call(t *testing.T) {
 	err := s.call(mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 3 && mType.numCalls == 1, "failed to call Foo.Sum")
 }
+
+func TestRegisterFunc(t *testing.T) {
+	server := NewServer("", "")
+	_ = server.Register(new(Foo))
+	err := RegisterFunc(server, "Foo.Mul", func(args Args, reply *int) error {
+		*reply = args.Num1 * args.Num2
+		return nil
+	})
+	_assert(err == nil, "register func error: %v", err)
+	err = RegisterFunc(server, "Foo.Sum", func(args Args, reply *int) error { return nil })
+	_assert(err != nil, "expect duplicate method error")
+	err = RegisterFunc(server, "Foo.Any", func(args interface{}, reply *int) error { return nil })
+	_assert(err != nil, "expect interface argument type to be rejected")
+	_, _, err = server.findService("Foo.Any")
+	_assert(err != nil, "expect rejected method not to be registered")
+
+	svc, mType, err := server.findService("Foo.Mul")
+	_assert(err == nil && len(svc.methods) == 2, "expect Foo to have Sum and Mul")
+	argv, replyv := mType.newArgv(), mType.newRpleyv()
+	argv.Set(reflect.ValueOf(Args{3, 4}))
+	err = svc.call(mType, argv, replyv)
+	_assert(err == nil && *replyv.Interface().(*int) == 12, "failed to call Foo.Mul")
+
+	reply, err := server.Invoke("Foo.Sum", func(argv interface{}) error {
+		*argv.(*Args) = Args{1, 2}
+		return nil
+	})
+	_assert(err == nil && *reply.(*int) == 3, "reflect methods still work after RegisterFunc")
+}
+
+type bar int
+
+func (b bar) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func TestServer_RegisterName(t *testing.T) {
+	r := registry.New(registry.DefaultTimeout)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	server := NewServer(ts.URL, "tcp@127.0.0.1:1")
+
+	err := server.Register(new(bar))
+	_assert(err != nil, "expect error for unexported type")
+	err = server.RegisterName("billing.v2.Invoice", new(bar))
+	_assert(err == nil, "register name error: %v", err)
+	_, mType, err := server.findService("billing.v2.Invoice.Sum")
+	_assert(err == nil && mType != nil, "failed to find billing.v2.Invoice.Sum")
+
+	_ = server.sendHeartbeat()
+	discover := func() string {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("X-Zrpc-Services", "billing.v2.Invoice.Sum")
+		req.Header.Set("X-Zrpc-Mode", "RoundRobin")
+		resp, _ := http.DefaultClient.Do(req)
+		return resp.Header.Get("X-Zrpc-Servers")
+	}
+	_assert(discover() == "tcp@127.0.0.1:1", "expect server to be registered")
+
+	err = server.Unregister("billing.v2.Invoice")
+	_assert(err == nil, "unregister error: %v", err)
+	_, _, err = server.findService("billing.v2.Invoice.Sum")
+	_assert(err != nil, "expect service to be removed")
+	_ = server.sendHeartbeat()
+	_assert(discover() == "", "expect registry to drop the unregistered service")
+	_assert(server.Unregister("billing.v2.Invoice") != nil, "expect error on second unregister")
+}
+
+func TestServer_HeartbeatRetry(t *testing.T) {
+	r := registry.New(registry.DefaultTimeout)
+	var posts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// 前两次心跳时注册中心不可用
+		if req.Method == http.MethodPost && atomic.AddInt32(&posts, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+	server := NewServer(ts.URL, "tcp@127.0.0.1:1")
+	_ = server.Register(new(Foo))
+	server.Heartbeat(20 * time.Millisecond)
+
+	d := registry.NewZRegistryDiscovery(ts.URL, time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for servers, _ := d.GetAll(); len(servers) != 1; servers, _ = d.GetAll() {
+		_assert(time.Now().Before(deadline), "expect heartbeats to recover after failures, got %v", servers)
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = server.Close()
+	time.Sleep(30 * time.Millisecond) // 等待可能正在进行的心跳结束
+	sent := atomic.LoadInt32(&posts)
+	time.Sleep(60 * time.Millisecond)
+	_assert(atomic.LoadInt32(&posts) == sent, "expect no heartbeats after Close")
+}
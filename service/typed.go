@@ -0,0 +1,50 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// RegisterFunc 以 "Service.Method" 注册一个类型化的处理函数。
+// 参数与应答类型在编译期确定，调用时不经过reflect.Value.Call；
+// 同名服务已存在时将方法加入该服务，方法重名则返回错误。
+// Req不能是接口类型：解码时无法确定具体类型
+func RegisterFunc[Req, Resp any](server *Server, serviceMethod string, fn func(Req, *Resp) error) error {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot <= 0 || dot == len(serviceMethod)-1 {
+		return errors.New("rpc server: service/method ill-formed: " + serviceMethod)
+	}
+	argType := reflect.TypeOf((*Req)(nil)).Elem()
+	if argType.Kind() == reflect.Interface {
+		return fmt.Errorf("rpc server: argument type of %s must not be an interface: %s", serviceMethod, argType)
+	}
+	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	mtype := &methodType{
+		ArgType:   argType,
+		ReplyType: reflect.TypeOf((*Resp)(nil)),
+		fn: func(argv, replyv interface{}) error {
+			return fn(argv.(Req), replyv.(*Resp))
+		},
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	// 复制方法表后整体替换，避免与正在处理的请求并发读写同一个map
+	s := &service{name: serviceName, methods: map[string]*methodType{}}
+	if svci, ok := server.serviceMap.Load(serviceName); ok {
+		old := svci.(*service)
+		if _, dup := old.methods[methodName]; dup {
+			return fmt.Errorf("rpc: method already defined: %s", serviceMethod)
+		}
+		s.typ, s.rcvr, s.version = old.typ, old.rcvr, old.version
+		for k, v := range old.methods {
+			s.methods[k] = v
+		}
+	}
+	s.methods[methodName] = mtype
+	server.serviceMap.Store(serviceName, s)
+	server.methodMap.Store(serviceMethod, true)
+	return nil
+}
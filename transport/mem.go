@@ -0,0 +1,212 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// MemNetwork 进程内传输的网络名，服务地址形如 "mem@name"
+const MemNetwork = "mem"
+
+var (
+	ErrListenerClosed = errors.New("transport: mem listener closed")
+	ErrAddrInUse      = errors.New("transport: mem address already in use")
+	ErrNoListener     = errors.New("transport: no mem listener on address")
+)
+
+var (
+	memMu        sync.Mutex
+	memListeners = map[string]*MemListener{}
+)
+
+// MemListener 进程内监听器，Accept返回的连接由带缓冲的管道构成
+type MemListener struct {
+	name   string
+	conns  chan net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+var _ net.Listener = (*MemListener)(nil)
+
+// ListenMem 以name注册一个进程内监听器，可直接交给Server.Listen使用
+func ListenMem(name string) (*MemListener, error) {
+	memMu.Lock()
+	defer memMu.Unlock()
+	if _, ok := memListeners[name]; ok {
+		return nil, ErrAddrInUse
+	}
+	l := &MemListener{
+		name:   name,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	memListeners[name] = l
+	return l, nil
+}
+
+// DialMem 连接到以name注册的进程内监听器，timeout为0表示不限制等待Accept的时间
+func DialMem(name string, timeout time.Duration) (net.Conn, error) {
+	memMu.Lock()
+	l, ok := memListeners[name]
+	memMu.Unlock()
+	if !ok {
+		return nil, ErrNoListener
+	}
+	client, server := Pipe(memAddr(name))
+	var expire <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expire = t.C
+	}
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, ErrNoListener
+	case <-expire:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (l *MemListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *MemListener) Close() error {
+	l.once.Do(func() {
+		memMu.Lock()
+		delete(memListeners, l.name)
+		memMu.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *MemListener) Addr() net.Addr {
+	return memAddr(l.name)
+}
+
+type memAddr string
+
+func (a memAddr) Network() string { return MemNetwork }
+func (a memAddr) String() string  { return string(a) }
+
+// Pipe 返回一对全双工的进程内连接，与net.Pipe不同，写入不会阻塞到对端读取为止
+func Pipe(addr net.Addr) (net.Conn, net.Conn) {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	return &pipeConn{r: a, w: b, addr: addr}, &pipeConn{r: b, w: a, addr: addr}
+}
+
+// 单向缓冲区，一端写入另一端读取
+type pipeBuffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      bytes.Buffer
+	closed   bool
+	deadline time.Time
+	timer    *time.Timer
+}
+
+func newPipeBuffer() *pipeBuffer {
+	p := &pipeBuffer{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *pipeBuffer) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.buf.Len() == 0 {
+		if p.closed {
+			return 0, io.EOF
+		}
+		if !p.deadline.IsZero() && !time.Now().Before(p.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		p.cond.Wait()
+	}
+	return p.buf.Read(b)
+}
+
+func (p *pipeBuffer) write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := p.buf.Write(b)
+	p.cond.Broadcast()
+	return n, err
+}
+
+func (p *pipeBuffer) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+}
+
+func (p *pipeBuffer) setDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deadline = t
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if !t.IsZero() {
+		// 到期时唤醒等待中的读取者
+		p.timer = time.AfterFunc(time.Until(t), func() {
+			p.mu.Lock()
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		})
+	}
+	p.cond.Broadcast()
+}
+
+type pipeConn struct {
+	r, w *pipeBuffer
+	addr net.Addr
+}
+
+var _ net.Conn = (*pipeConn)(nil)
+
+func (c *pipeConn) Read(b []byte) (int, error)  { return c.r.read(b) }
+func (c *pipeConn) Write(b []byte) (int, error) { return c.w.write(b) }
+
+func (c *pipeConn) Close() error {
+	c.r.close()
+	c.w.close()
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.r.setDeadline(t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.r.setDeadline(t)
+	return nil
+}
+
+// 写入不会阻塞，写超时无需处理
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed:"+msg, v...))
+	}
+}
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe(memAddr("pipe"))
+	// 写入不等待对端读取
+	for i := 0; i < 3; i++ {
+		_, err := a.Write([]byte("abc"))
+		_assert(err == nil, "write error: %v", err)
+	}
+	buf := make([]byte, 9)
+	n, err := io.ReadFull(b, buf)
+	_assert(err == nil && n == 9 && string(buf) == "abcabcabc", "unexpected read %q, %v", buf[:n], err)
+
+	_, _ = b.Write([]byte("xy"))
+	_ = a.Close()
+	// 关闭后仍可读完已缓冲的数据，之后返回EOF
+	n, err = a.Read(buf)
+	_assert(err == nil && string(buf[:n]) == "xy", "expect buffered data after close, got %q, %v", buf[:n], err)
+	_, err = a.Read(buf)
+	_assert(err == io.EOF, "expect EOF after close, got %v", err)
+	_, err = b.Read(buf)
+	_assert(err == io.EOF, "expect EOF on the peer, got %v", err)
+	_, err = b.Write([]byte("z"))
+	_assert(errors.Is(err, io.ErrClosedPipe), "expect ErrClosedPipe, got %v", err)
+}
+
+func TestPipe_Deadline(t *testing.T) {
+	a, b := Pipe(memAddr("pipe"))
+	defer func() { _ = a.Close() }()
+	buf := make([]byte, 4)
+
+	_ = b.SetReadDeadline(time.Now().Add(-time.Second))
+	_, err := b.Read(buf)
+	_assert(errors.Is(err, os.ErrDeadlineExceeded), "expect deadline exceeded for past deadline, got %v", err)
+
+	// 阻塞中的读取在到期时被唤醒
+	start := time.Now()
+	_ = b.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err = b.Read(buf)
+	_assert(errors.Is(err, os.ErrDeadlineExceeded), "expect deadline exceeded, got %v", err)
+	_assert(time.Since(start) >= 20*time.Millisecond, "read returned before deadline")
+
+	// 清除期限后恢复正常读取
+	_ = b.SetReadDeadline(time.Time{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = a.Write([]byte("ok"))
+	}()
+	n, err := b.Read(buf)
+	_assert(err == nil && string(buf[:n]) == "ok", "expect read after deadline reset, got %q, %v", buf[:n], err)
+
+	// 有数据可读时不受已过期的期限影响
+	_, _ = a.Write([]byte("hi"))
+	_ = b.SetDeadline(time.Now().Add(-time.Second))
+	n, err = b.Read(buf)
+	_assert(err == nil && string(buf[:n]) == "hi", "expect buffered data despite deadline, got %q, %v", buf[:n], err)
+}
+
+func TestMemListener(t *testing.T) {
+	l, err := ListenMem("transport-test")
+	_assert(err == nil, "listen error: %v", err)
+	_, err = ListenMem("transport-test")
+	_assert(err == ErrAddrInUse, "expect ErrAddrInUse, got %v", err)
+	_assert(l.Addr().Network() == MemNetwork && l.Addr().String() == "transport-test", "unexpected addr %v", l.Addr())
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 4)
+		n, _ := conn.Read(buf)
+		_, _ = conn.Write(buf[:n])
+	}()
+	conn, err := DialMem("transport-test", time.Second)
+	_assert(err == nil, "dial error: %v", err)
+	_, _ = conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	_assert(err == nil && string(buf) == "ping", "expect echo, got %q, %v", buf, err)
+	_ = conn.Close()
+
+	// 没有Accept时按timeout放弃
+	_, err = DialMem("transport-test", 10*time.Millisecond)
+	_assert(errors.Is(err, os.ErrDeadlineExceeded), "expect dial timeout, got %v", err)
+
+	_ = l.Close()
+	_ = l.Close()
+	_, err = l.Accept()
+	_assert(err == ErrListenerClosed, "expect ErrListenerClosed, got %v", err)
+	_, err = DialMem("transport-test", time.Second)
+	_assert(err == ErrNoListener, "expect ErrNoListener after close, got %v", err)
+
+	// 关闭后名字可以再次使用
+	l, err = ListenMem("transport-test")
+	_assert(err == nil, "expect name to be reusable, got %v", err)
+	_ = l.Close()
+}
@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 构造一个原始帧，mask非nil时对负载加掩码
+func rawFrame(fin bool, opcode byte, payload []byte, mask []byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	var maskBit byte
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(n))
+	}
+	if mask == nil {
+		return append(frame, payload...)
+	}
+	frame = append(frame, mask...)
+	for i, c := range payload {
+		frame = append(frame, c^mask[i%4])
+	}
+	return frame
+}
+
+// 返回服务端的wsConn与模拟客户端的原始连接
+func wsServerPair() (*wsConn, net.Conn) {
+	raw, conn := Pipe(memAddr("ws"))
+	return newWSConn(conn, bufio.NewReader(conn), false), raw
+}
+
+var testMask = []byte{0x12, 0x34, 0x56, 0x78}
+
+func TestWSConn_RoundTrip(t *testing.T) {
+	a, b := Pipe(memAddr("ws"))
+	client := newWSConn(a, bufio.NewReader(a), true)
+	server := newWSConn(b, bufio.NewReader(b), false)
+	defer func() { _ = client.Close() }()
+
+	// 覆盖7位、16位与64位三种长度编码
+	for _, size := range []int{5, 300, 70000} {
+		msg := bytes.Repeat([]byte{'x'}, size)
+		_, err := client.Write(msg)
+		_assert(err == nil, "client write error: %v", err)
+		got := make([]byte, size)
+		_, err = io.ReadFull(server, got)
+		_assert(err == nil && bytes.Equal(got, msg), "server read %d bytes: %v", size, err)
+
+		_, err = server.Write(msg)
+		_assert(err == nil, "server write error: %v", err)
+		_, err = io.ReadFull(client, got)
+		_assert(err == nil && bytes.Equal(got, msg), "client read %d bytes: %v", size, err)
+	}
+}
+
+func TestWSConn_Masking(t *testing.T) {
+	server, raw := wsServerPair()
+	_, _ = raw.Write(rawFrame(true, opBinary, []byte("hello"), testMask))
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(server, buf)
+	_assert(err == nil && string(buf) == "hello", "expect unmasked payload, got %q, %v", buf, err)
+
+	// 客户端发来的帧必须带掩码
+	_, _ = raw.Write(rawFrame(true, opBinary, []byte("hello"), nil))
+	_, err = server.Read(buf)
+	_assert(err != nil && strings.Contains(err.Error(), "masking"), "expect masking error, got %v", err)
+
+	// 服务端发出的帧不带掩码
+	server, raw = wsServerPair()
+	_, _ = server.Write([]byte("hi"))
+	head := make([]byte, 4)
+	_, err = io.ReadFull(raw, head)
+	_assert(err == nil && bytes.Equal(head, []byte{0x80 | opBinary, 2, 'h', 'i'}), "unexpected server frame %x", head)
+}
+
+func TestWSConn_Fragments(t *testing.T) {
+	server, raw := wsServerPair()
+	// 一条消息分成三个分片，每个分片使用不同的掩码
+	_, _ = raw.Write(rawFrame(false, opBinary, []byte("hel"), testMask))
+	_, _ = raw.Write(rawFrame(false, opContinuation, []byte("lo "), []byte{1, 2, 3, 4}))
+	_, _ = raw.Write(rawFrame(true, opContinuation, []byte("world"), []byte{9, 8, 7, 6}))
+	buf := make([]byte, 11)
+	_, err := io.ReadFull(server, buf)
+	_assert(err == nil && string(buf) == "hello world", "expect reassembled message, got %q, %v", buf, err)
+}
+
+func TestWSConn_ControlFrames(t *testing.T) {
+	server, raw := wsServerPair()
+	// 分片之间穿插的ping得到pong应答，pong被忽略，不影响数据
+	_, _ = raw.Write(rawFrame(false, opBinary, []byte("ab"), testMask))
+	_, _ = raw.Write(rawFrame(true, opPing, []byte("p1"), testMask))
+	_, _ = raw.Write(rawFrame(true, opPong, []byte("ignored"), testMask))
+	_, _ = raw.Write(rawFrame(true, opContinuation, []byte("cd"), testMask))
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(server, buf)
+	_assert(err == nil && string(buf) == "abcd", "expect data around control frames, got %q, %v", buf, err)
+	pong := make([]byte, 4)
+	_, err = io.ReadFull(raw, pong)
+	_assert(err == nil && bytes.Equal(pong, []byte{0x80 | opPong, 2, 'p', '1'}), "expect pong echoing ping payload, got %x", pong)
+
+	// close帧被回应并以EOF结束读取
+	_, _ = raw.Write(rawFrame(true, opClose, []byte{0x03, 0xe8}, testMask))
+	_, err = server.Read(buf)
+	_assert(err == io.EOF, "expect EOF after close frame, got %v", err)
+	closing := make([]byte, 4)
+	_, err = io.ReadFull(raw, closing)
+	_assert(err == nil && bytes.Equal(closing, []byte{0x80 | opClose, 2, 0x03, 0xe8}), "expect close echo, got %x", closing)
+
+	// 控制帧负载不能超过125字节
+	server, raw = wsServerPair()
+	_, _ = raw.Write(rawFrame(true, opPing, make([]byte, 126), testMask))
+	_, err = server.Read(buf)
+	_assert(err != nil && strings.Contains(err.Error(), "too large"), "expect control frame too large, got %v", err)
+
+	server, raw = wsServerPair()
+	_, _ = raw.Write(rawFrame(true, 0x3, []byte("x"), testMask))
+	_, err = server.Read(buf)
+	_assert(err != nil && strings.Contains(err.Error(), "opcode"), "expect unknown opcode error, got %v", err)
+}
+
+func TestDialWebSocket(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := AcceptWebSocket(w, req)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = io.Copy(conn, conn)
+	}))
+	defer ts.Close()
+
+	conn, err := DialWebSocket("ws"+strings.TrimPrefix(ts.URL, "http")+"/echo", time.Second)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = conn.Close() }()
+	_, _ = conn.Write([]byte("echo"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	_assert(err == nil && string(buf) == "echo", "expect echo, got %q, %v", buf, err)
+
+	// 普通http请求不能升级
+	resp, err := http.Get(ts.URL)
+	_assert(err == nil && resp.StatusCode == http.StatusBadRequest, "expect 400 without upgrade, got %v", err)
+	_ = resp.Body.Close()
+}